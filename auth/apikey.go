@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/respond"
+	"github.com/iconimpact/go-core/strutil"
+	"go.uber.org/zap"
+)
+
+// HeaderAPIKey is the default request header of API keys.
+const HeaderAPIKey = "X-API-Key"
+
+// DefaultAPIKeyHashPurpose is the strutil.Hash description used for hashing
+// API keys if none is set with WithAPIKeyHashPurpose.
+const DefaultAPIKeyHashPurpose = "go-core auth API key"
+
+// APIKey is an issued API key. Only the hash of the key is stored.
+type APIKey struct {
+	// Hash is the HashAPIKey of the key.
+	Hash string
+	// Owner identifies the integration the key was issued to.
+	Owner string
+	// Scopes are the scopes granted to the key.
+	Scopes []string
+	// Expires is the time at which the key expires, zero if it never does.
+	Expires time.Time
+}
+
+// PrincipalID returns the owner of the key.
+func (k *APIKey) PrincipalID() string {
+	return k.Owner
+}
+
+// HasScope reports whether the scope is granted to the key.
+func (k *APIKey) HasScope(scope string) bool {
+	return containsString(k.Scopes, scope)
+}
+
+// APIKeyStore looks up API keys by their hash.
+type APIKeyStore interface {
+	// LookupAPIKey returns the API key having the hash or false if there is
+	// none.
+	LookupAPIKey(ctx context.Context, hash string) (APIKey, bool, error)
+}
+
+// APIKeyMap is a static APIKeyStore mapping hashes to API keys.
+type APIKeyMap map[string]APIKey
+
+// LookupAPIKey returns the API key having the hash.
+func (m APIKeyMap) LookupAPIKey(ctx context.Context, hash string) (APIKey, bool, error) {
+	k, ok := m[hash]
+	return k, ok, nil
+}
+
+// HashAPIKey returns the hash of the API key for storing it, using
+// strutil.Hash with the purpose as description.
+func HashAPIKey(key, purpose string) string {
+	return strutil.Hash(key, purpose)
+}
+
+// APIKeyAuth authorizes HTTP requests by looking up the API key of the
+// request by its hash. It is safe for concurrent use.
+type APIKeyAuth struct {
+	store         APIKeyStore
+	header        string
+	queryParam    string
+	purpose       string
+	now           func() time.Time
+	requestLogger func(r *http.Request) *zap.Logger
+	errorHandler  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewAPIKeyAuth returns a new APIKeyAuth configured by the options. Without
+// options no key is known, keys are read from the X-API-Key header and
+// hashed with DefaultAPIKeyHashPurpose, and failures are responded with
+// respond.JSONError without logging.
+func NewAPIKeyAuth(opts ...APIKeyOption) *APIKeyAuth {
+	a := &APIKeyAuth{
+		store:   APIKeyMap(nil),
+		header:  HeaderAPIKey,
+		purpose: DefaultAPIKeyHashPurpose,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.errorHandler == nil {
+		a.errorHandler = a.respondError
+	}
+	return a
+}
+
+// APIKeyMiddleware reads the API key from the X-API-Key header, or the
+// header and query parameter set with WithAPIKeyHeader and
+// WithAPIKeyQueryParam, and looks it up in the store by its HashAPIKey.
+// Unknown and expired keys are responded as errors.Unauthorized with
+// respond.JSONError. The resolved key is available to next handlers through
+// APIKeyFromContext and APIKeyOwnerFromContext.
+// It is a shorthand for NewAPIKeyAuth with the corresponding options, which
+// are applied before opts.
+func APIKeyMiddleware(
+	store APIKeyStore,
+	requestLogger func(r *http.Request) *zap.Logger,
+	opts ...APIKeyOption,
+) func(next http.Handler) http.Handler {
+
+	opts = append([]APIKeyOption{
+		WithAPIKeyStore(store),
+		WithAPIKeyRequestLogger(requestLogger),
+	}, opts...)
+
+	return NewAPIKeyAuth(opts...).Middleware
+}
+
+// Middleware returns an HTTP middleware passing only authorized requests to
+// next, with the APIKey in the request context. Failures are handled by the
+// error handler.
+func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, err := a.Authenticate(r)
+		if err != nil {
+			a.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithAPIKey(r.Context(), k)))
+	})
+}
+
+// Authenticate looks up the API key of the request. Authorization failures
+// are returned as errors.Unauthorized, store failures as errors.Internal.
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*APIKey, error) {
+	key := a.requestKey(r)
+	if key == "" {
+		err := fmt.Errorf("invalid authorization: API key is missing")
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	hash := HashAPIKey(key, a.purpose)
+	k, ok, err := a.store.LookupAPIKey(r.Context(), hash)
+	if err != nil {
+		err = fmt.Errorf("looking up API key: %w", err)
+		return nil, errors.E(err, errors.Internal, "internal server error")
+	}
+	// the comparison guards against stores matching hashes loosely, e.g.
+	// case-insensitively
+	if !ok || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) != 1 {
+		err = fmt.Errorf("invalid authorization: unknown API key")
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	if !k.Expires.IsZero() && !a.now().Before(k.Expires) {
+		err = fmt.Errorf("invalid authorization: API key of owner '%s' expired at '%s'",
+			k.Owner, k.Expires)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	return &k, nil
+}
+
+// requestKey returns the API key from the header or query parameter.
+func (a *APIKeyAuth) requestKey(r *http.Request) string {
+	if a.header != "" {
+		if key := r.Header.Get(a.header); key != "" {
+			return key
+		}
+	}
+	if a.queryParam != "" {
+		return r.URL.Query().Get(a.queryParam)
+	}
+	return ""
+}
+
+// respondError is the default error handler responding with
+// respond.JSONError.
+func (a *APIKeyAuth) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var log *zap.Logger
+	if a.requestLogger != nil {
+		log = a.requestLogger(r)
+	}
+	respond.JSONError(w, log, err)
+}
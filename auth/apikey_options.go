@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// APIKeyOption configures an APIKeyAuth created by NewAPIKeyAuth or
+// APIKeyMiddleware.
+type APIKeyOption func(*APIKeyAuth)
+
+// WithAPIKeyStore sets the store for looking up API keys by their hash.
+func WithAPIKeyStore(store APIKeyStore) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.store = store
+	}
+}
+
+// WithAPIKeyHeader sets the request header of API keys, an empty name
+// disables reading keys from headers. Defaults to HeaderAPIKey.
+func WithAPIKeyHeader(name string) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.header = name
+	}
+}
+
+// WithAPIKeyQueryParam enables reading API keys from the query parameter if
+// the header is not set. Keys in URLs may end up in access logs, so use it
+// only for clients not able to set headers.
+func WithAPIKeyQueryParam(name string) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.queryParam = name
+	}
+}
+
+// WithAPIKeyHashPurpose sets the strutil.Hash description used for hashing
+// API keys, see HashAPIKey. Defaults to DefaultAPIKeyHashPurpose.
+func WithAPIKeyHashPurpose(purpose string) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.purpose = purpose
+	}
+}
+
+// WithAPIKeyClock sets the function returning the current time, useful for
+// testing. Defaults to time.Now.
+func WithAPIKeyClock(now func() time.Time) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.now = now
+	}
+}
+
+// WithAPIKeyRequestLogger sets the function returning the logger of a
+// request used by the default error handler. Without it failures are not
+// logged.
+func WithAPIKeyRequestLogger(requestLogger func(r *http.Request) *zap.Logger) APIKeyOption {
+	return func(a *APIKeyAuth) {
+		a.requestLogger = requestLogger
+	}
+}
+
+// WithAPIKeyErrorHandler sets the function handling authorization failures.
+// Defaults to respond.JSONError with the logger of WithAPIKeyRequestLogger.
+func WithAPIKeyErrorHandler(
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error),
+) APIKeyOption {
+
+	return func(a *APIKeyAuth) {
+		a.errorHandler = errorHandler
+	}
+}
@@ -0,0 +1,144 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/strutil"
+	"github.com/iconimpact/go-core/testhelpers"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	logUnsugared, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	log, logs := testhelpers.ObserveLogs(t, logUnsugared.Sugar())
+
+	now := time.Unix(1700000000, 0)
+	key := "some-api-key"
+	expiredKey := "some-expired-api-key"
+	store := auth.APIKeyMap{
+		auth.HashAPIKey(key, auth.DefaultAPIKeyHashPurpose): {
+			Hash:   auth.HashAPIKey(key, auth.DefaultAPIKeyHashPurpose),
+			Owner:  "some-partner",
+			Scopes: []string{"orders:read"},
+		},
+		auth.HashAPIKey(expiredKey, auth.DefaultAPIKeyHashPurpose): {
+			Hash:    auth.HashAPIKey(expiredKey, auth.DefaultAPIKeyHashPurpose),
+			Owner:   "old-partner",
+			Expires: now,
+		},
+	}
+	require.Equal(t, strutil.Hash(key, auth.DefaultAPIKeyHashPurpose),
+		auth.HashAPIKey(key, auth.DefaultAPIKeyHashPurpose))
+
+	newHandler := func(opts ...auth.APIKeyOption) http.Handler {
+		opts = append(opts, auth.WithAPIKeyClock(func() time.Time { return now }))
+		return auth.APIKeyMiddleware(
+			store,
+			func(r *http.Request) *zap.Logger { return log.Desugar() },
+			opts...,
+		)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner, ok := auth.APIKeyOwnerFromContext(r.Context())
+			require.True(t, ok)
+			k, ok := auth.APIKeyFromContext(r.Context())
+			require.True(t, ok)
+			fmt.Fprintf(w, "%s %v", owner, k.HasScope("orders:read"))
+		}))
+	}
+	serve := func(h http.Handler, target, header, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if header != "" {
+			r.Header.Set(header, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	h := newHandler()
+	w := serve(h, "/", auth.HeaderAPIKey, key)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "some-partner true", w.Body.String())
+
+	for _, tt := range []struct {
+		name, target, key, err string
+	}{
+		{name: "missing", target: "/", err: "API key is missing"},
+		{name: "unknown", target: "/", key: "unknown-key", err: "unknown API key"},
+		{name: "expired", target: "/", key: expiredKey, err: "expired"},
+		{name: "query param disabled", target: "/?api_key=" + key, err: "API key is missing"},
+	} {
+		w := serve(h, tt.target, auth.HeaderAPIKey, tt.key)
+		require.Equal(t, http.StatusUnauthorized, w.Code, tt.name)
+		require.Contains(t, w.Body.String(), "invalid authorization")
+		testhelpers.RequireLastLogEntry(t, logs, zapcore.ErrorLevel, "",
+			map[string]string{"error": tt.err})
+	}
+
+	// custom header and query param
+	h = newHandler(auth.WithAPIKeyHeader("Api-Token"), auth.WithAPIKeyQueryParam("api_key"))
+	require.Equal(t, http.StatusOK, serve(h, "/", "Api-Token", key).Code)
+	require.Equal(t, http.StatusOK, serve(h, "/?api_key="+key, "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, serve(h, "/", auth.HeaderAPIKey, key).Code)
+
+	// keys hashed for another purpose are unknown
+	h = newHandler(auth.WithAPIKeyHashPurpose("other purpose"))
+	require.Equal(t, http.StatusUnauthorized, serve(h, "/", auth.HeaderAPIKey, key).Code)
+}
+
+// apiKeyStoreFunc is an APIKeyStore calling the function.
+type apiKeyStoreFunc func(ctx context.Context, hash string) (auth.APIKey, bool, error)
+
+func (f apiKeyStoreFunc) LookupAPIKey(ctx context.Context, hash string) (auth.APIKey, bool, error) {
+	return f(ctx, hash)
+}
+
+func TestAPIKeyAuthStore(t *testing.T) {
+	hash := auth.HashAPIKey("some-api-key", auth.DefaultAPIKeyHashPurpose)
+
+	// store matching hashes case-insensitively
+	a := auth.NewAPIKeyAuth(auth.WithAPIKeyStore(apiKeyStoreFunc(
+		func(ctx context.Context, h string) (auth.APIKey, bool, error) {
+			if strings.EqualFold(h, hash) {
+				return auth.APIKey{Hash: hash, Owner: "some-partner"}, true, nil
+			}
+			return auth.APIKey{}, false, nil
+		})))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(auth.HeaderAPIKey, "some-api-key")
+	k, err := a.Authenticate(r)
+	require.NoError(t, err)
+	require.Equal(t, "some-partner", k.Owner)
+
+	// stored hash must match exactly
+	a = auth.NewAPIKeyAuth(auth.WithAPIKeyStore(apiKeyStoreFunc(
+		func(ctx context.Context, h string) (auth.APIKey, bool, error) {
+			return auth.APIKey{Hash: strings.ToUpper(h), Owner: "some-partner"}, true, nil
+		})))
+	_, err = a.Authenticate(r)
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+
+	// store errors are internal errors
+	a = auth.NewAPIKeyAuth(auth.WithAPIKeyStore(apiKeyStoreFunc(
+		func(ctx context.Context, h string) (auth.APIKey, bool, error) {
+			return auth.APIKey{}, false, fmt.Errorf("some store error")
+		})))
+	_, err = a.Authenticate(r)
+	require.True(t, errors.IsKind(errors.Internal, err))
+	require.Contains(t, err.Error(), "some store error")
+
+	w := httptest.NewRecorder()
+	a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
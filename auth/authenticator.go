@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/respond"
+	"go.uber.org/zap"
+)
+
+// Principal is a caller authenticated by an Authenticator, an
+// *HMACPrincipal, *JWTClaims, *APIKey or Principals.
+type Principal interface {
+	// PrincipalID returns the ID of the caller, i.e. the app ID, token
+	// subject or API key owner.
+	PrincipalID() string
+	// HasScope reports whether the scope is granted to the caller.
+	HasScope(scope string) bool
+}
+
+// Principals are the principals authenticated by AllOf.
+type Principals []Principal
+
+// PrincipalID returns the ID of the first principal having one.
+func (p Principals) PrincipalID() string {
+	for _, principal := range p {
+		if id := principal.PrincipalID(); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// HasScope reports whether the scope is granted to every principal, so
+// with AllOf(app, user) the scope of an app is not granted to any user
+// using it and vice versa.
+func (p Principals) HasScope(scope string) bool {
+	for _, principal := range p {
+		if !principal.HasScope(scope) {
+			return false
+		}
+	}
+	return len(p) > 0
+}
+
+// Authenticator authenticates HTTP requests. It is implemented by HMAC, JWT
+// and APIKeyAuth and can be combined with AnyOf and AllOf.
+type Authenticator interface {
+	// AuthenticateRequest returns the principal of the request. Failures
+	// are returned as errors.Unauthorized, unexpected errors with other
+	// kinds like errors.Internal.
+	AuthenticateRequest(r *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc is a function implementing Authenticator.
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+// AuthenticateRequest calls f.
+func (f AuthenticatorFunc) AuthenticateRequest(r *http.Request) (Principal, error) {
+	return f(r)
+}
+
+// AuthenticateRequest implements Authenticator, see Authenticate.
+func (h *HMAC) AuthenticateRequest(r *http.Request) (Principal, error) {
+	p, err := h.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AuthenticateRequest implements Authenticator, see Authenticate.
+func (j *JWT) AuthenticateRequest(r *http.Request) (Principal, error) {
+	c, err := j.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AuthenticateRequest implements Authenticator, see Authenticate.
+func (a *APIKeyAuth) AuthenticateRequest(r *http.Request) (Principal, error) {
+	k, err := a.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AnyOf returns an Authenticator trying the authenticators in order and
+// returning the principal of the first one succeeding. If all of them fail
+// the errors are returned as AuthErrors, with errors.Unauthorized if all of
+// them are unauthorized, otherwise with errors.Internal.
+func AnyOf(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		errs := make(AuthErrors, 0, len(authenticators))
+		for _, a := range authenticators {
+			p, err := a.AuthenticateRequest(r)
+			if err == nil {
+				return p, nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			err := fmt.Errorf("invalid authorization: no authenticators")
+			return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+		}
+
+		for _, err := range errs {
+			if !errors.IsKind(errors.Unauthorized, err) {
+				return nil, errors.E(errs, errors.Internal, "internal server error")
+			}
+		}
+		return nil, errors.E(errs, errors.Unauthorized, "invalid authorization")
+	})
+}
+
+// AllOf returns an Authenticator requiring all authenticators to succeed,
+// e.g. an app HMAC and a user JWT. They are called in order and the error
+// of the first failing one is returned. On success the principals are
+// returned as Principals.
+func AllOf(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		if len(authenticators) == 0 {
+			err := fmt.Errorf("invalid authorization: no authenticators")
+			return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+		}
+
+		principals := make(Principals, 0, len(authenticators))
+		for _, a := range authenticators {
+			p, err := a.AuthenticateRequest(r)
+			if err != nil {
+				return nil, err
+			}
+			principals = append(principals, p)
+		}
+		return principals, nil
+	})
+}
+
+// AuthErrors are the errors of all authenticators combined by AnyOf.
+type AuthErrors []error
+
+func (e AuthErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target.
+func (e AuthErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors matching target.
+func (e AuthErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAuth returns a middleware which only passes requests authenticated
+// by the authenticator, usually AnyOf or AllOf, to next with the principal in
+// the request context, see PrincipalFromContext. Failures are responded with
+// respond.JSONError. requestLogger can be nil.
+func RequireAuth(
+	a Authenticator,
+	requestLogger func(r *http.Request) *zap.Logger,
+) func(next http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.AuthenticateRequest(r)
+			if err != nil {
+				var log *zap.Logger
+				if requestLogger != nil {
+					log = requestLogger(r)
+				}
+				respond.JSONError(w, log, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), p)))
+		})
+	}
+}
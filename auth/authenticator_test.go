@@ -0,0 +1,242 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnyOfAllOf(t *testing.T) {
+	appSecret := []byte("some-app-secret")
+	jwtSecret := []byte("some-jwt-secret")
+
+	nonceExpiration := 2 * time.Second
+	newHMAC := func() *auth.HMAC {
+		return auth.NewHMAC(
+			auth.WithHMACSecrets(map[string][]byte{"some-app": appSecret}),
+			auth.WithHMACNonceCache(cache.New(nonceExpiration, nonceExpiration)),
+			auth.WithHMACScopes(map[string][]string{"some-app": {"orders:read", "profile"}}),
+		)
+	}
+	jwt := auth.NewJWT(auth.WithJWTKeys(auth.StaticJWTKeys{{Key: jwtSecret}}))
+	token := signJWT(t, "HS256", "", jwtSecret, map[string]interface{}{
+		"sub":   "some-user",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"scope": "profile orders:write",
+	})
+
+	newRequest := func(signHMAC, bearer bool) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		if signHMAC {
+			nonce := uuid.NewString()
+			timestamp := fmt.Sprintf("%d", time.Now().Unix())
+			auth.SetHMACHeaders(r, "some-app", nonce, timestamp,
+				auth.HMACSign(appSecret, []byte(nonce+timestamp)))
+		}
+		if bearer {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	// any of
+	anyOf := auth.AnyOf(newHMAC(), jwt)
+
+	p, err := anyOf.AuthenticateRequest(newRequest(true, false))
+	require.NoError(t, err)
+	require.Equal(t, "some-app", p.PrincipalID())
+	require.IsType(t, &auth.HMACPrincipal{}, p)
+
+	p, err = anyOf.AuthenticateRequest(newRequest(false, true))
+	require.NoError(t, err)
+	require.Equal(t, "some-user", p.PrincipalID())
+	require.True(t, p.HasScope("orders:write"))
+	require.False(t, p.HasScope("orders"))
+
+	_, err = anyOf.AuthenticateRequest(newRequest(false, false))
+	require.Error(t, err)
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+	require.True(t, errors.Is(err, auth.ErrHMACMissingHeader))
+	require.Contains(t, err.Error(), "not a bearer token")
+	var authErrs auth.AuthErrors
+	require.True(t, errors.As(err, &authErrs))
+	require.Len(t, authErrs, 2)
+
+	// all of
+	allOf := auth.AllOf(newHMAC(), jwt)
+
+	p, err = allOf.AuthenticateRequest(newRequest(true, true))
+	require.NoError(t, err)
+	require.Equal(t, "some-app", p.PrincipalID())
+	require.Len(t, p, 2)
+	// scopes must be granted to all principals
+	require.True(t, p.HasScope("profile"))
+	require.False(t, p.HasScope("orders:read"))
+	require.False(t, p.HasScope("orders:write"))
+	require.False(t, auth.Principals{}.HasScope("profile"))
+
+	_, err = allOf.AuthenticateRequest(newRequest(true, false))
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+	require.Contains(t, err.Error(), "not a bearer token")
+
+	// no authenticators
+	_, err = auth.AnyOf().AuthenticateRequest(newRequest(true, true))
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+	_, err = auth.AllOf().AuthenticateRequest(newRequest(true, true))
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+
+	// unexpected errors are internal
+	failing := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		return nil, errors.E(fmt.Errorf("some store error"), errors.Internal)
+	})
+	_, err = auth.AnyOf(jwt, failing).AuthenticateRequest(newRequest(false, false))
+	require.True(t, errors.IsKind(errors.Internal, err))
+	require.Contains(t, err.Error(), "some store error")
+}
+
+func TestRequireAuth(t *testing.T) {
+	jwtSecret := []byte("some-jwt-secret")
+	apiKey := "some-api-key"
+	hash := auth.HashAPIKey(apiKey, auth.DefaultAPIKeyHashPurpose)
+
+	authenticator := auth.AnyOf(
+		auth.NewJWT(auth.WithJWTKeys(auth.StaticJWTKeys{{Key: jwtSecret}})),
+		auth.NewAPIKeyAuth(auth.WithAPIKeyStore(auth.APIKeyMap{
+			hash: {Hash: hash, Owner: "some-partner", Scopes: []string{"orders:read"}},
+		})),
+	)
+
+	var calls int
+	handler := auth.RequireAuth(authenticator, nil)(
+		auth.RequireScope("orders:read", nil)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				p, ok := auth.PrincipalFromContext(r.Context())
+				require.True(t, ok)
+				fmt.Fprint(w, p.PrincipalID())
+			})))
+
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(auth.HeaderAPIKey, apiKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "some-partner", w.Body.String())
+
+	// single response for all failures
+	w = serve("", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `{"msg":"invalid authorization"}`, w.Body.String())
+	require.Empty(t, w.Header().Get("WWW-Authenticate"))
+
+	// scope of the JWT is missing
+	token := signJWT(t, "HS256", "", jwtSecret, map[string]interface{}{
+		"sub": "some-user",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"scp": []string{"profile"},
+	})
+	w = serve("Authorization", "Bearer "+token)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, 1, calls)
+}
+
+func TestAllOfRequireScope(t *testing.T) {
+	appSecret := []byte("some-app-secret")
+	jwtSecret := []byte("some-jwt-secret")
+	nonceExpiration := 2 * time.Second
+
+	hmacAuth := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{"some-app": appSecret}),
+		auth.WithHMACNonceCache(cache.New(nonceExpiration, nonceExpiration)),
+		auth.WithHMACScopes(map[string][]string{"some-app": {"admin", "orders:read"}}),
+	)
+	jwtAuth := auth.NewJWT(auth.WithJWTKeys(auth.StaticJWTKeys{{Key: jwtSecret}}))
+	token := signJWT(t, "HS256", "", jwtSecret, map[string]interface{}{
+		"sub":   "some-user",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"scope": "orders:read profile",
+	})
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	allOf := auth.RequireAuth(auth.AllOf(hmacAuth, jwtAuth), nil)
+	stacked := func(next http.Handler) http.Handler {
+		return hmacAuth.Middleware(auth.JWTMiddleware(auth.StaticJWTKeys{{Key: jwtSecret}}, nil)(next))
+	}
+
+	serve := func(mw func(http.Handler) http.Handler, scope string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		nonce := uuid.NewString()
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		auth.SetHMACHeaders(r, "some-app", nonce, timestamp,
+			auth.HMACSign(appSecret, []byte(nonce+timestamp)))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mw(auth.RequireScope(scope, nil)(ok)).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for name, mw := range map[string]func(http.Handler) http.Handler{
+		"all of":  allOf,
+		"stacked": stacked,
+	} {
+		// granted to app and user
+		require.Equal(t, http.StatusOK, serve(mw, "orders:read"), name)
+		// the user does not get the scope of the app
+		require.Equal(t, http.StatusForbidden, serve(mw, "admin"), name)
+		// the app does not get the scope of the user
+		require.Equal(t, http.StatusForbidden, serve(mw, "profile"), name)
+	}
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	_, ok := auth.PrincipalFromContext(context.Background())
+	require.False(t, ok)
+
+	// nil principals are ignored
+	ctx := auth.ContextWithJWTClaims(context.Background(), nil)
+	_, ok = auth.PrincipalFromContext(ctx)
+	require.False(t, ok)
+
+	// stacked middlewares
+	hmacPrincipal := &auth.HMACPrincipal{AppID: "some-app"}
+	claims := &auth.JWTClaims{Subject: "some-user"}
+	key := &auth.APIKey{Owner: "some-partner"}
+	ctx = auth.ContextWithHMACPrincipal(context.Background(), hmacPrincipal)
+	ctx = auth.ContextWithJWTClaims(ctx, claims)
+
+	p, ok := auth.PrincipalFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, auth.Principals{hmacPrincipal, claims}, p)
+
+	gotHMAC, ok := auth.HMACPrincipalFromContext(ctx)
+	require.True(t, ok)
+	require.Same(t, hmacPrincipal, gotHMAC)
+	gotClaims, ok := auth.JWTClaimsFromContext(ctx)
+	require.True(t, ok)
+	require.Same(t, claims, gotClaims)
+	_, ok = auth.APIKeyFromContext(ctx)
+	require.False(t, ok)
+
+	ctx = auth.ContextWithPrincipal(ctx, auth.Principals{key})
+	p, _ = auth.PrincipalFromContext(ctx)
+	require.Equal(t, auth.Principals{hmacPrincipal, claims, key}, p)
+	gotKey, ok := auth.APIKeyFromContext(ctx)
+	require.True(t, ok)
+	require.Same(t, key, gotKey)
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// HMACPrincipal is the app authenticated by HMACMiddleware.
+type HMACPrincipal struct {
+	// AppID is the authenticated app ID.
+	AppID string
+	// Nonce is the nonce of the authenticated request.
+	Nonce string
+	// Timestamp is the signature timestamp of the authenticated request.
+	Timestamp time.Time
+	// KeyVersion is the version of the secret the request was signed with.
+	KeyVersion string
+	// Scopes are the scopes granted to the app ID.
+	Scopes []string
+}
+
+// PrincipalID returns the app ID.
+func (p *HMACPrincipal) PrincipalID() string {
+	return p.AppID
+}
+
+// HasScope reports whether the scope is granted to the app.
+func (p *HMACPrincipal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx holding the principal. If ctx
+// already holds principals, e.g. of stacked middlewares, they are kept and
+// the principals are combined as Principals.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	if existing := principalsFromContext(ctx); len(existing) > 0 {
+		combined := append(Principals(nil), existing...)
+		if ps, ok := p.(Principals); ok {
+			p = append(combined, ps...)
+		} else {
+			p = append(combined, p)
+		}
+	}
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// PrincipalFromContext returns the principal authenticated by RequireAuth,
+// HMACMiddleware, JWTMiddleware or APIKeyMiddleware, or false if the request
+// was not authenticated.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(Principal)
+	return p, ok && p != nil
+}
+
+// principalsFromContext returns the principals of ctx in the order they
+// were authenticated.
+func principalsFromContext(ctx context.Context) Principals {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if ps, ok := p.(Principals); ok {
+		return ps
+	}
+	return Principals{p}
+}
+
+// ContextWithHMACPrincipal returns a copy of ctx holding the principal.
+// It is used by HMACMiddleware and can be used for testing handlers.
+func ContextWithHMACPrincipal(ctx context.Context, p *HMACPrincipal) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return ContextWithPrincipal(ctx, p)
+}
+
+// HMACPrincipalFromContext returns the principal authenticated by
+// HMACMiddleware or false if the request was not authenticated by it.
+func HMACPrincipalFromContext(ctx context.Context) (*HMACPrincipal, bool) {
+	ps := principalsFromContext(ctx)
+	for i := len(ps) - 1; i >= 0; i-- {
+		if p, ok := ps[i].(*HMACPrincipal); ok {
+			return p, p != nil
+		}
+	}
+	return nil, false
+}
+
+// AppIDFromContext returns the app ID authenticated by HMACMiddleware or
+// false if the request was not authenticated by it.
+func AppIDFromContext(ctx context.Context) (string, bool) {
+	p, ok := HMACPrincipalFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return p.AppID, true
+}
+
+// ContextWithJWTClaims returns a copy of ctx holding the claims.
+// It is used by JWTMiddleware and can be used for testing handlers.
+func ContextWithJWTClaims(ctx context.Context, c *JWTClaims) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return ContextWithPrincipal(ctx, c)
+}
+
+// JWTClaimsFromContext returns the claims of the token verified by
+// JWTMiddleware or false if the request was not authenticated by it.
+func JWTClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	ps := principalsFromContext(ctx)
+	for i := len(ps) - 1; i >= 0; i-- {
+		if c, ok := ps[i].(*JWTClaims); ok {
+			return c, c != nil
+		}
+	}
+	return nil, false
+}
+
+// ContextWithAPIKey returns a copy of ctx holding the API key.
+// It is used by APIKeyMiddleware and can be used for testing handlers.
+func ContextWithAPIKey(ctx context.Context, k *APIKey) context.Context {
+	if k == nil {
+		return ctx
+	}
+	return ContextWithPrincipal(ctx, k)
+}
+
+// APIKeyFromContext returns the API key resolved by APIKeyMiddleware or
+// false if the request was not authenticated by it.
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	ps := principalsFromContext(ctx)
+	for i := len(ps) - 1; i >= 0; i-- {
+		if k, ok := ps[i].(*APIKey); ok {
+			return k, k != nil
+		}
+	}
+	return nil, false
+}
+
+// APIKeyOwnerFromContext returns the owner of the API key resolved by
+// APIKeyMiddleware or false if the request was not authenticated by it.
+func APIKeyOwnerFromContext(ctx context.Context) (string, bool) {
+	k, ok := APIKeyFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return k.Owner, true
+}
@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHMACPrincipalFromContext(t *testing.T) {
+	// not authenticated
+	_, ok := auth.HMACPrincipalFromContext(context.Background())
+	require.False(t, ok)
+	_, ok = auth.AppIDFromContext(context.Background())
+	require.False(t, ok)
+
+	// nil principal
+	ctx := auth.ContextWithHMACPrincipal(context.Background(), nil)
+	_, ok = auth.HMACPrincipalFromContext(ctx)
+	require.False(t, ok)
+
+	// authenticated by middleware
+	appID := "some-app-id"
+	secret := auth.HMACSecret{Version: "2", Key: []byte("some-secret")}
+	provider := auth.NewMemorySecretProvider()
+	require.NoError(t, provider.SetSecrets(appID, secret))
+
+	var principal *auth.HMACPrincipal
+	var principalAppID string
+	nonceExpiration := 2 * time.Second
+	handler := auth.HMACMiddleware(
+		nil,
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACSecretProvider(provider),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok = auth.HMACPrincipalFromContext(r.Context())
+		require.True(t, ok)
+		principalAppID, ok = auth.AppIDFromContext(r.Context())
+		require.True(t, ok)
+	}))
+
+	now := time.Now()
+	nonce := uuid.NewString()
+	timestamp := fmt.Sprintf("%d", now.Unix())
+	r := httptest.NewRequest("GET", "/", nil)
+	auth.SetHMACHeaders(r, appID, nonce, timestamp,
+		auth.HMACSign(secret.Key, []byte(nonce+timestamp)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	require.Equal(t, &auth.HMACPrincipal{
+		AppID:      appID,
+		Nonce:      nonce,
+		Timestamp:  time.Unix(now.Unix(), 0),
+		KeyVersion: "2",
+	}, principal)
+	require.Equal(t, appID, principalAppID)
+}
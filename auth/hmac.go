@@ -1,16 +1,19 @@
 package auth
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/iconimpact/go-core/errors"
 	"github.com/iconimpact/go-core/respond"
+	"github.com/patrickmn/go-cache"
 	"go.uber.org/zap"
 )
 
@@ -22,90 +25,477 @@ const (
 	HMACHeaderTimestamp = "X-Auth-Timestamp"
 )
 
+// DefaultHMACNonceExpiration is the default duration for which signature
+// timestamps are valid and nonces must be unique.
+const DefaultHMACNonceExpiration = 2 * time.Minute
+
+// DefaultHMACMaxBodySize is the default maximum size in bytes of request
+// bodies read for authorization.
+const DefaultHMACMaxBodySize = 10 << 20
+
+// HMACHeaderNames are the names of the request headers used for HMAC
+// authorization.
+type HMACHeaderNames struct {
+	AppID     string
+	Nonce     string
+	Timestamp string
+	Signature string
+	// Algorithm is the optional header for negotiating the HMACAlgorithm.
+	// Algorithms can not be negotiated if it is empty.
+	Algorithm string
+}
+
+// DefaultHMACHeaderNames are the X-Auth-* request headers.
+var DefaultHMACHeaderNames = HMACHeaderNames{
+	AppID:     HMACHeaderAppID,
+	Nonce:     HMACHeaderNonce,
+	Timestamp: HMACHeaderTimestamp,
+	Signature: HMACHeaderSignature,
+	Algorithm: HMACHeaderAlgorithm,
+}
+
+// Set sets the specified HMAC auth headers on an HTTP request.
+func (n HMACHeaderNames) Set(r *http.Request, appID, nonce, timestamp, signature string) {
+	r.Header.Set(n.AppID, appID)
+	r.Header.Set(n.Nonce, nonce)
+	r.Header.Set(n.Timestamp, timestamp)
+	r.Header.Set(n.Signature, signature)
+}
+
+// Get returns the HMAC auth headers from an HTTP request.
+func (n HMACHeaderNames) Get(r *http.Request) (appID, nonce, timestamp, signature string) {
+	appID = r.Header.Get(n.AppID)
+	nonce = r.Header.Get(n.Nonce)
+	timestamp = r.Header.Get(n.Timestamp)
+	signature = r.Header.Get(n.Signature)
+	return
+}
+
 // HMACNonceCache is an interface abstracting away the cache implementation
 // for caching nonces used for HMAC authorization.
+// If the cache also implements HMACNonceCacheAdder (like go-cache) its Add
+// method is used for atomically checking and caching nonces, otherwise Get
+// and Set are guarded by a lock of the middleware instance.
 type HMACNonceCache interface {
 	Get(string) (interface{}, bool)
 	Set(string, interface{}, time.Duration)
 }
 
+// HMAC authorizes HTTP requests by validating the signature header which is
+// a HEX-encoded HMAC of the payload built for the request and the shared
+// secret of the app ID. It is safe for concurrent use.
+type HMAC struct {
+	secrets         SecretProvider
+	nonces          HMACNonceStore
+	nonceExpiration time.Duration
+	payload         HMACPayloadFunc
+	algorithm       HMACAlgorithm
+	algorithms      []HMACAlgorithm
+	newHash         func() hash.Hash
+	headers         HMACHeaderNames
+	maxBodySize     int64
+	scopes          map[string][]string
+	skew            time.Duration
+	now             func() time.Time
+	observer        HMACObserver
+	requestLogger   func(r *http.Request) *zap.Logger
+	errorHandler    func(w http.ResponseWriter, r *http.Request, err error)
+
+	messageSignatures bool
+	messageKeys       map[string]ed25519.PublicKey
+	messageComponents []string
+}
+
+// NewHMAC returns a new HMAC configured by the options. Without options
+// no app ID is known, signatures are SHA512 HMACs of nonce and timestamp,
+// timestamps are valid for 2 minutes, nonces are recorded in an in-process
+// cache and failures are responded with respond.JSONError without logging.
+func NewHMAC(opts ...HMACOption) *HMAC {
+	h := &HMAC{
+		secrets:         SecretMap(nil),
+		nonceExpiration: DefaultHMACNonceExpiration,
+		payload:         HMACLegacyPayload,
+		algorithm:       DefaultHMACAlgorithm,
+		headers:         DefaultHMACHeaderNames,
+		maxBodySize:     DefaultHMACMaxBodySize,
+		now:             time.Now,
+
+		messageComponents: DefaultMessageSignatureComponents,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.nonces == nil {
+		h.nonces = NewHMACNonceCacheStore(
+			cache.New(h.nonceExpiration, h.nonceExpiration))
+	}
+	if h.errorHandler == nil {
+		h.errorHandler = h.respondError
+	}
+	return h
+}
+
 // HMACMiddleware validates the signature header which is a HEX-encoded SHA512
-// HMAC of nonce, timestamp and secret.
+// HMAC of the payload built for the request and the secret. By default the
+// payload is nonce and timestamp, use WithHMACPayload to change it.
 // Signature timestamp is considered valid for nonceExpiration duration and
-// nonce values must be unique within this timeframe.
+// nonce values must be unique per app ID within this timeframe. Timestamps in
+// the future are rejected unless allowed by WithHMACClockSkew.
+// Failures are logged with an "hmac_failure" field holding the HMACFailure
+// reason. The authenticated app is available to next handlers through
+// HMACPrincipalFromContext and AppIDFromContext.
+// It is a shorthand for NewHMAC with the corresponding options, which are
+// applied before opts.
 func HMACMiddleware(
 	secretsPerAppIDs map[string][]byte,
 	nonceCache HMACNonceCache,
 	nonceExpiration time.Duration,
 	requestLogger func(r *http.Request) *zap.Logger,
+	opts ...HMACOption,
 ) func(next http.Handler) http.Handler {
 
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			log := requestLogger(r)
-
-			appID, nonce, timestamp, signature := GetHMACHeaders(r)
-
-			if len(appID) == 0 {
-				err := fmt.Errorf(
-					"invalid authorization: request header %s is missing or empty",
-					HMACHeaderAppID)
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-
-			sharedSecret, ok := secretsPerAppIDs[appID]
-			if !ok {
-				err := fmt.Errorf(
-					"invalid authorization: request header %s value '%s' is an unknown app ID",
-					HMACHeaderAppID, appID)
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-
-			_, found := nonceCache.Get(nonce)
-			if found {
-				err := fmt.Errorf("invalid authorization: nonce was already used")
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-
-			ts, err := strconv.ParseInt(timestamp, 10, 64)
-			if err != nil {
-				err = fmt.Errorf("invalid authorization timestamp: %w", err)
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-			t := time.Unix(ts, 0)
-
-			age := time.Since(t)
-			if age > nonceExpiration {
-				err = fmt.Errorf(
-					"invalid authorization: timestamp '%s' (unix second %d) has age %s "+
-						"older than nonce expiration %s",
-					t, ts, age, nonceExpiration)
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-
-			err = HMACVerify(sharedSecret, []byte(nonce+timestamp), signature)
-			if err != nil {
-				err = fmt.Errorf("invalid authorization signature: %v", err)
-				err = errors.E(err, errors.Unauthorized, "invalid authorization")
-				respond.JSONError(w, log, err)
-				return
-			}
-
-			nonceCache.Set(nonce, struct{}{}, nonceExpiration)
-
-			next.ServeHTTP(w, r)
-		})
+	opts = append([]HMACOption{
+		WithHMACSecrets(secretsPerAppIDs),
+		WithHMACNonceCache(nonceCache),
+		WithHMACNonceExpiration(nonceExpiration),
+		WithHMACRequestLogger(requestLogger),
+	}, opts...)
+	return NewHMAC(opts...).Middleware
+}
+
+// Middleware returns an HTTP middleware passing only authorized requests to
+// next, with the authenticated HMACPrincipal in the request context.
+// Failures are handled by the error handler.
+func (h *HMAC) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := h.Authenticate(r)
+		if err != nil {
+			h.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithHMACPrincipal(r.Context(), p)))
+	})
+}
+
+// Authenticate validates the HMAC auth headers of the request and records
+// its nonce. Authorization failures are returned as errors.Unauthorized
+// having an HMACFailure reason. The observer is called with the result.
+// The body is only read if the payload or a content digest covers it, and
+// rejected if it is larger than the maximum body size.
+func (h *HMAC) Authenticate(r *http.Request) (*HMACPrincipal, error) {
+	var (
+		p     *HMACPrincipal
+		appID string
+		err   error
+	)
+	body := limitBody(r, h.maxBodySize)
+	if h.messageSignatures && r.Header.Get(HeaderSignatureInput) != "" {
+		p, appID, err = h.authenticateMessage(r)
+	} else {
+		appID = r.Header.Get(h.headers.AppID)
+		p, err = h.authenticate(r)
+	}
+	if body != nil {
+		if body.exceeded {
+			p = nil
+			err = newHMACError(ErrHMACBodyTooLarge,
+				"invalid authorization: request body is larger than %d bytes", h.maxBodySize)
+			err = errors.E(err, errors.Unauthorized, "invalid authorization")
+		}
+		// the body was not read for the authorization, do not limit it for
+		// next handlers
+		if r.Body == body {
+			r.Body = body.ReadCloser
+		}
+	}
+	if h.observer != nil {
+		h.observer(r, appID, err)
+	}
+	return p, err
+}
+
+// respondError is the default error handler responding with
+// respond.JSONError and logging the failure reason.
+func (h *HMAC) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var log *zap.Logger
+	if h.requestLogger != nil {
+		log = h.requestLogger(r)
+	}
+	if reason, ok := HMACFailureReason(err); ok && log != nil {
+		log = log.With(zap.String("hmac_failure", string(reason)))
+	}
+	respond.JSONError(w, log, err)
+}
+
+func (h *HMAC) authenticate(r *http.Request) (*HMACPrincipal, error) {
+	appID, nonce, timestamp, signature := h.headers.Get(r)
+
+	if len(appID) == 0 {
+		err := newHMACError(ErrHMACMissingHeader,
+			"invalid authorization: request header %s is missing or empty",
+			h.headers.AppID)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	sharedSecrets, ok := h.secrets.Secrets(appID)
+	if !ok {
+		err := newHMACError(ErrHMACUnknownAppID,
+			"invalid authorization: request header %s value '%s' is an unknown app ID",
+			h.headers.AppID, appID)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	if len(nonce) == 0 || len(signature) == 0 {
+		err := newHMACError(ErrHMACMissingHeader,
+			"invalid authorization: request headers %s and %s must not be empty",
+			h.headers.Nonce, h.headers.Signature)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	if len(timestamp) == 0 {
+		err := newHMACError(ErrHMACMissingHeader,
+			"invalid authorization timestamp: request header %s is missing or empty",
+			h.headers.Timestamp)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		err = newHMACError(ErrHMACInvalidTimestamp,
+			"invalid authorization timestamp: %w", err)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	t := time.Unix(ts, 0)
+
+	now := h.now()
+	if err := h.checkTimestamp(t, now); err != nil {
+		return nil, err
+	}
+
+	payload, err := h.payload(r, nonce, timestamp)
+	if err != nil {
+		err = newHMACError(ErrHMACInvalidSignature,
+			"invalid authorization payload: %v", err)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	newHash, enc, err := h.signatureAlgorithm(r)
+	if err != nil {
+		err = newHMACError(ErrHMACUnsupportedAlgorithm,
+			"invalid authorization algorithm: %v", err)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	secret, err := hmacVerifyAny(newHash, enc, sharedSecrets, payload, signature)
+	if err != nil {
+		err = newHMACError(ErrHMACInvalidSignature,
+			"invalid authorization signature: %v", err)
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	if err := h.addNonce(appID, nonce, t, now); err != nil {
+		return nil, err
+	}
+
+	return &HMACPrincipal{
+		AppID:      appID,
+		Nonce:      nonce,
+		Timestamp:  t,
+		KeyVersion: secret.Version,
+		Scopes:     h.scopes[appID],
+	}, nil
+}
+
+// authenticateMessage validates the HTTP message signature (RFC 9421) of the
+// request and records its nonce. The key ID is the app ID.
+func (h *HMAC) authenticateMessage(r *http.Request) (*HMACPrincipal, string, error) {
+	if r.Header.Get(HeaderSignature) == "" {
+		err := newHMACError(ErrHMACMissingHeader,
+			"invalid authorization: request header %s is missing or empty",
+			HeaderSignature)
+		return nil, "", errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	s, err := readMessageSignature(r, "")
+	if err != nil {
+		err = newHMACError(ErrHMACInvalidSignature,
+			"invalid authorization signature: %v", err)
+		return nil, "", errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	appID := s.KeyID
+
+	if appID == "" || s.Nonce == "" || s.Created.IsZero() {
+		err = newHMACError(ErrHMACMissingHeader,
+			"invalid authorization: signature parameters keyid, nonce and created must not be empty")
+		return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	var keys []MessageSignatureKey
+	var versions []string
+	if pub, ok := h.messageKeys[appID]; ok {
+		keys = append(keys, MessageSignatureKey{
+			ID: appID, Algorithm: MessageSignatureEd25519, PublicKey: pub})
+		versions = append(versions, "")
+	}
+	if secrets, ok := h.secrets.Secrets(appID); ok {
+		for _, secret := range secrets {
+			keys = append(keys, MessageSignatureKey{
+				ID: appID, Algorithm: MessageSignatureHMACSHA256, Secret: secret.Key})
+			versions = append(versions, secret.Version)
+		}
+	}
+	if len(keys) == 0 {
+		err = newHMACError(ErrHMACUnknownAppID,
+			"invalid authorization: signature keyid '%s' is an unknown app ID", appID)
+		return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	if s.Algorithm != "" && s.Algorithm != MessageSignatureHMACSHA256 &&
+		s.Algorithm != MessageSignatureEd25519 {
+		err = newHMACError(ErrHMACUnsupportedAlgorithm,
+			"invalid authorization algorithm: '%s' is not supported", s.Algorithm)
+		return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	now := h.now()
+	if err := h.checkTimestamp(s.Created, now); err != nil {
+		return nil, appID, err
+	}
+	if !s.Expires.IsZero() && now.After(s.Expires.Add(h.skew)) {
+		err = newHMACError(ErrHMACExpired,
+			"invalid authorization: signature expired at '%s' plus clock skew %s",
+			s.Expires, h.skew)
+		return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	required := h.messageComponents
+	if r.ContentLength != 0 {
+		required = append(required[:len(required):len(required)], "content-digest")
+	}
+	for _, c := range required {
+		if !containsString(s.Components, c) {
+			err = newHMACError(ErrHMACInvalidSignature,
+				"invalid authorization signature: component '%s' is not covered", c)
+			return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+		}
+	}
+
+	version := ""
+	err = errors.E(fmt.Errorf("no key for algorithm '%s'", s.Algorithm))
+	for i, key := range keys {
+		if s.Algorithm != "" && s.Algorithm != key.Algorithm {
+			continue
+		}
+		if err = s.verify(r, key); err == nil {
+			version = versions[i]
+			break
+		}
+	}
+	if err != nil {
+		err = newHMACError(ErrHMACInvalidSignature,
+			"invalid authorization signature: %v", err)
+		return nil, appID, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	if err := h.addNonce(appID, s.Nonce, s.Created, now); err != nil {
+		return nil, appID, err
+	}
+
+	return &HMACPrincipal{
+		AppID:      appID,
+		Nonce:      s.Nonce,
+		Timestamp:  s.Created,
+		KeyVersion: version,
+		Scopes:     h.scopes[appID],
+	}, appID, nil
+}
+
+// checkTimestamp returns an error if the signature timestamp t is in the
+// future or expired at now, taking the clock skew into account.
+func (h *HMAC) checkTimestamp(t, now time.Time) error {
+	age := now.Sub(t)
+	if age < -h.skew {
+		err := newHMACError(ErrHMACInvalidTimestamp,
+			"invalid authorization: timestamp '%s' (unix second %d) is %s "+
+				"in the future, more than clock skew %s",
+			t, t.Unix(), -age, h.skew)
+		return errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	if age > h.nonceExpiration+h.skew {
+		err := newHMACError(ErrHMACExpired,
+			"invalid authorization: timestamp '%s' (unix second %d) has age %s "+
+				"older than nonce expiration %s plus clock skew %s",
+			t, t.Unix(), age, h.nonceExpiration, h.skew)
+		return errors.E(err, errors.Unauthorized, "invalid authorization")
 	}
+	return nil
+}
+
+// addNonce records the nonce of the app ID, returning an error if it was
+// already used.
+func (h *HMAC) addNonce(appID, nonce string, t, now time.Time) error {
+	added, err := h.nonces.AddNonce(
+		HMACNonceKey(appID, nonce),
+		hmacNonceTTL(t, now, h.nonceExpiration, h.skew))
+	if err != nil {
+		err = fmt.Errorf("recording authorization nonce: %w", err)
+		return errors.E(err, errors.Internal, "internal server error")
+	}
+	if !added {
+		err = newHMACError(ErrHMACReplayedNonce,
+			"invalid authorization: nonce was already used")
+		return errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	return nil
+}
+
+// signatureAlgorithm returns the hash function and encoding negotiated with
+// the algorithm header or the default ones if the header is not set.
+func (h *HMAC) signatureAlgorithm(r *http.Request) (func() hash.Hash, HMACEncoding, error) {
+	var header string
+	if h.headers.Algorithm != "" {
+		header = r.Header.Get(h.headers.Algorithm)
+	}
+
+	if header == "" {
+		if h.newHash != nil {
+			return h.newHash, h.algorithm.Encoding, nil
+		}
+		newHash, err := h.algorithm.newHash()
+		return newHash, h.algorithm.Encoding, err
+	}
+
+	alg, err := ParseHMACAlgorithm(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if h.algorithms != nil && !containsHMACAlgorithm(h.algorithms, alg) {
+		return nil, "", errors.E(fmt.Errorf("algorithm '%s' is not allowed", alg))
+	}
+	newHash, err := alg.newHash()
+	return newHash, alg.Encoding, err
+}
+
+func containsHMACAlgorithm(algs []HMACAlgorithm, alg HMACAlgorithm) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// hmacNonceTTL returns how long a nonce must be cached so that it can not be
+// reused as long as its timestamp is accepted, i.e. until the timestamp is
+// older than nonceExpiration plus skew.
+func hmacNonceTTL(t, now time.Time, nonceExpiration, skew time.Duration) time.Duration {
+	ttl := t.Add(nonceExpiration + skew).Sub(now)
+	// zero or negative durations mean default or no expiration for caches
+	// like go-cache
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return ttl
 }
 
 // HMACSign creates a new hex-encoded SHA512 HMAC signature for the specified
@@ -119,31 +509,54 @@ func HMACSign(secret, payload []byte) string {
 // HMACVerify verifies the given hex-encoded SHA512 HMAC signature for the
 // specified secret and payload.
 func HMACVerify(secret, payload []byte, signature string) error {
-	s, err := hex.DecodeString(signature)
-	if err != nil {
-		return errors.E(err)
-	}
-	mac := hmac.New(sha512.New, secret)
-	mac.Write(payload)
-	if !hmac.Equal(s, mac.Sum(nil)) {
-		return errors.E(fmt.Errorf("signature mismatch"))
+	return verifyHMAC(sha512.New, HMACEncodingHex, secret, payload, signature)
+}
+
+// hmacVerifyAny verifies the signature with each of the secrets and returns
+// the first matching one.
+func hmacVerifyAny(
+	newHash func() hash.Hash,
+	enc HMACEncoding,
+	secrets []HMACSecret,
+	payload []byte,
+	signature string,
+) (HMACSecret, error) {
+
+	err := errors.E(fmt.Errorf("no valid secret"))
+	for _, secret := range secrets {
+		err = verifyHMAC(newHash, enc, secret.Key, payload, signature)
+		if err == nil {
+			return secret, nil
+		}
 	}
-	return nil
+	return HMACSecret{}, err
 }
 
 // SetHMACHeaders sets the specified HMAC auth headers on an HTTP request.
 func SetHMACHeaders(r *http.Request, appID, nonce, timestamp, signature string) {
-	r.Header.Set(HMACHeaderAppID, appID)
-	r.Header.Set(HMACHeaderNonce, nonce)
-	r.Header.Set(HMACHeaderTimestamp, timestamp)
-	r.Header.Set(HMACHeaderSignature, signature)
+	DefaultHMACHeaderNames.Set(r, appID, nonce, timestamp, signature)
+}
+
+// HMACSignRequest signs an outgoing HTTP request with the payload built by
+// the specified payload function and sets the HMAC auth headers on it.
+// The payload function must match the one used by the receiving middleware.
+func HMACSignRequest(
+	r *http.Request,
+	appID string,
+	secret []byte,
+	nonce, timestamp string,
+	payload HMACPayloadFunc,
+) error {
+
+	p, err := payload(r, nonce, timestamp)
+	if err != nil {
+		return err
+	}
+	SetHMACHeaders(r, appID, nonce, timestamp, HMACSign(secret, p))
+	return nil
 }
 
 // GetHMACHeaders returns the HMAC auth headers from an HTTP request.
 func GetHMACHeaders(r *http.Request) (appID, nonce, timestamp, signature string) {
-	appID = r.Header.Get(HMACHeaderAppID)
-	nonce = r.Header.Get(HMACHeaderNonce)
-	timestamp = r.Header.Get(HMACHeaderTimestamp)
-	signature = r.Header.Get(HMACHeaderSignature)
-	return
+	return DefaultHMACHeaderNames.Get(r)
 }
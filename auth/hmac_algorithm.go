@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// HMACHeaderAlgorithm is the optional request header for negotiating the
+// HMACAlgorithm of the signature, e.g. "sha256:base64".
+const HMACHeaderAlgorithm = "X-Auth-Algorithm"
+
+// Hash functions registered by default.
+const (
+	HMACHashSHA256     = "sha256"
+	HMACHashSHA384     = "sha384"
+	HMACHashSHA512     = "sha512"
+	HMACHashSHA512_256 = "sha512-256"
+)
+
+// HMACEncoding is the text encoding of HMAC signatures.
+type HMACEncoding string
+
+// Supported signature encodings. Base64 signatures are padded, base64url
+// signatures are not, both are accepted with and without padding.
+const (
+	HMACEncodingHex       HMACEncoding = "hex"
+	HMACEncodingBase64    HMACEncoding = "base64"
+	HMACEncodingBase64URL HMACEncoding = "base64url"
+)
+
+var hmacEncodings = []HMACEncoding{
+	HMACEncodingHex, HMACEncodingBase64, HMACEncodingBase64URL,
+}
+
+// HMACAlgorithm is the hash function and encoding of HMAC signatures.
+type HMACAlgorithm struct {
+	// Hash is the name of a registered hash function.
+	Hash string
+	// Encoding is the text encoding of the signature.
+	Encoding HMACEncoding
+}
+
+// DefaultHMACAlgorithm is the algorithm used if no algorithm is negotiated,
+// a hex-encoded SHA512 HMAC.
+var DefaultHMACAlgorithm = HMACAlgorithm{
+	Hash:     HMACHashSHA512,
+	Encoding: HMACEncodingHex,
+}
+
+var (
+	hmacHashesMu sync.RWMutex
+	hmacHashes   = map[string]func() hash.Hash{
+		HMACHashSHA256:     sha256.New,
+		HMACHashSHA384:     sha512.New384,
+		HMACHashSHA512:     sha512.New,
+		HMACHashSHA512_256: sha512.New512_256,
+	}
+)
+
+// RegisterHMACHash registers a hash function under the name, making it
+// available for HMAC signatures.
+func RegisterHMACHash(name string, newHash func() hash.Hash) {
+	hmacHashesMu.Lock()
+	defer hmacHashesMu.Unlock()
+
+	hmacHashes[name] = newHash
+}
+
+// HMACAlgorithms returns all algorithms of the registered hash functions
+// and supported encodings.
+func HMACAlgorithms() []HMACAlgorithm {
+	hmacHashesMu.RLock()
+	names := make([]string, 0, len(hmacHashes))
+	for name := range hmacHashes {
+		names = append(names, name)
+	}
+	hmacHashesMu.RUnlock()
+	sort.Strings(names)
+
+	algs := make([]HMACAlgorithm, 0, len(names)*len(hmacEncodings))
+	for _, name := range names {
+		for _, enc := range hmacEncodings {
+			algs = append(algs, HMACAlgorithm{Hash: name, Encoding: enc})
+		}
+	}
+	return algs
+}
+
+// ParseHMACAlgorithm parses an algorithm in the format of the algorithm
+// header, "<hash>" or "<hash>:<encoding>", e.g. "sha256:base64". The encoding
+// defaults to hex.
+func ParseHMACAlgorithm(s string) (HMACAlgorithm, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	alg := HMACAlgorithm{
+		Hash:     strings.ToLower(strings.TrimSpace(parts[0])),
+		Encoding: HMACEncodingHex,
+	}
+	if len(parts) == 2 {
+		alg.Encoding = HMACEncoding(strings.ToLower(strings.TrimSpace(parts[1])))
+	}
+	if err := alg.validate(); err != nil {
+		return HMACAlgorithm{}, err
+	}
+	return alg, nil
+}
+
+// String returns the algorithm in the format of the algorithm header.
+func (a HMACAlgorithm) String() string {
+	return a.Hash + ":" + string(a.Encoding)
+}
+
+// Sign creates a new signature for the specified secret and payload.
+func (a HMACAlgorithm) Sign(secret, payload []byte) (string, error) {
+	newHash, err := a.newHash()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(newHash, secret)
+	mac.Write(payload)
+	return a.encode(mac.Sum(nil)), nil
+}
+
+// Verify verifies the signature for the specified secret and payload.
+func (a HMACAlgorithm) Verify(secret, payload []byte, signature string) error {
+	newHash, err := a.newHash()
+	if err != nil {
+		return err
+	}
+	return verifyHMAC(newHash, a.Encoding, secret, payload, signature)
+}
+
+func (a HMACAlgorithm) validate() error {
+	if _, err := a.newHash(); err != nil {
+		return err
+	}
+	for _, enc := range hmacEncodings {
+		if a.Encoding == enc {
+			return nil
+		}
+	}
+	return errors.E(fmt.Errorf("unsupported HMAC encoding '%s'", a.Encoding))
+}
+
+func (a HMACAlgorithm) newHash() (func() hash.Hash, error) {
+	hmacHashesMu.RLock()
+	defer hmacHashesMu.RUnlock()
+
+	newHash, ok := hmacHashes[a.Hash]
+	if !ok {
+		return nil, errors.E(fmt.Errorf("unsupported HMAC hash '%s'", a.Hash))
+	}
+	return newHash, nil
+}
+
+func (a HMACAlgorithm) encode(sum []byte) string {
+	switch a.Encoding {
+	case HMACEncodingBase64:
+		return base64.StdEncoding.EncodeToString(sum)
+	case HMACEncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// decodeHMACSignature decodes the signature, accepting base64 signatures
+// with and without padding.
+func decodeHMACSignature(enc HMACEncoding, signature string) ([]byte, error) {
+	switch enc {
+	case HMACEncodingBase64:
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(signature, "="))
+	case HMACEncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(signature, "="))
+	}
+	return hex.DecodeString(signature)
+}
+
+func verifyHMAC(
+	newHash func() hash.Hash,
+	enc HMACEncoding,
+	secret, payload []byte,
+	signature string,
+) error {
+
+	s, err := decodeHMACSignature(enc, signature)
+	if err != nil {
+		return errors.E(err)
+	}
+	mac := hmac.New(newHash, secret)
+	mac.Write(payload)
+	if !hmac.Equal(s, mac.Sum(nil)) {
+		return errors.E(fmt.Errorf("signature mismatch"))
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package auth_test
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseHMACAlgorithm(t *testing.T) {
+	tests := []struct {
+		in   string
+		want auth.HMACAlgorithm
+		err  bool
+	}{
+		{in: "sha256", want: auth.HMACAlgorithm{Hash: "sha256", Encoding: "hex"}},
+		{in: "SHA384:Base64", want: auth.HMACAlgorithm{Hash: "sha384", Encoding: "base64"}},
+		{in: " sha512-256 : base64url ", want: auth.HMACAlgorithm{Hash: "sha512-256", Encoding: "base64url"}},
+		{in: "sha512:hex", want: auth.DefaultHMACAlgorithm},
+		{in: "", err: true},
+		{in: "md5", err: true},
+		{in: "sha256:base32", err: true},
+	}
+	for _, tt := range tests {
+		got, err := auth.ParseHMACAlgorithm(tt.in)
+		if tt.err {
+			require.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.want, got, tt.in)
+
+		// round trip
+		again, err := auth.ParseHMACAlgorithm(got.String())
+		require.NoError(t, err)
+		require.Equal(t, got, again)
+	}
+}
+
+func TestHMACAlgorithmSignVerify(t *testing.T) {
+	secret := []byte("some-secret")
+	payload := []byte("some-payload")
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+
+	tests := []struct {
+		alg  auth.HMACAlgorithm
+		want string
+	}{
+		{alg: auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingHex},
+			want: hex.EncodeToString(sum)},
+		{alg: auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingBase64},
+			want: base64.StdEncoding.EncodeToString(sum)},
+		{alg: auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingBase64URL},
+			want: base64.RawURLEncoding.EncodeToString(sum)},
+	}
+	for _, tt := range tests {
+		got, err := tt.alg.Sign(secret, payload)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got, tt.alg.String())
+		require.NoError(t, tt.alg.Verify(secret, payload, got))
+		require.Error(t, tt.alg.Verify([]byte("x"), payload, got))
+	}
+
+	// base64 is accepted with and without padding
+	b64 := auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingBase64}
+	require.NoError(t, b64.Verify(secret, payload, base64.RawStdEncoding.EncodeToString(sum)))
+	b64url := auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingBase64URL}
+	require.NoError(t, b64url.Verify(secret, payload, base64.URLEncoding.EncodeToString(sum)))
+
+	// the default algorithm matches HMACSign
+	sig, err := auth.DefaultHMACAlgorithm.Sign(secret, payload)
+	require.NoError(t, err)
+	require.Equal(t, auth.HMACSign(secret, payload), sig)
+
+	// all algorithms sign and verify
+	for _, alg := range auth.HMACAlgorithms() {
+		sig, err := alg.Sign(secret, payload)
+		require.NoError(t, err)
+		require.NoError(t, alg.Verify(secret, payload, sig), alg.String())
+	}
+
+	_, err = auth.HMACAlgorithm{Hash: "unknown", Encoding: "hex"}.Sign(secret, payload)
+	require.Error(t, err)
+}
+
+func TestRegisterHMACHash(t *testing.T) {
+	auth.RegisterHMACHash("md5", md5.New)
+
+	alg, err := auth.ParseHMACAlgorithm("md5:base64")
+	require.NoError(t, err)
+	sig, err := alg.Sign([]byte("some-secret"), []byte("some-payload"))
+	require.NoError(t, err)
+	require.NoError(t, alg.Verify([]byte("some-secret"), []byte("some-payload"), sig))
+	require.Contains(t, auth.HMACAlgorithms(), alg)
+}
+
+func TestHMACMiddlewareAlgorithms(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+
+	newHandler := func(opts ...auth.HMACOption) http.Handler {
+		return auth.HMACMiddleware(
+			map[string][]byte{appID: secret},
+			cache.New(nonceExpiration, nonceExpiration),
+			nonceExpiration,
+			func(r *http.Request) *zap.Logger { return zap.NewNop() },
+			opts...,
+		)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+
+	var lastErr error
+	observer := auth.WithHMACObserver(func(r *http.Request, appID string, err error) {
+		lastErr = err
+	})
+
+	serve := func(h http.Handler, header string, alg auth.HMACAlgorithm) int {
+		nonce := uuid.NewString()
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		sig, err := alg.Sign(secret, []byte(nonce+timestamp))
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp, sig)
+		if header != "" {
+			r.Header.Set(auth.HMACHeaderAlgorithm, header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	sha256Base64 := auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingBase64}
+
+	// defaults are unchanged, all algorithms can be negotiated
+	h := newHandler(observer)
+	require.Equal(t, http.StatusOK, serve(h, "", auth.DefaultHMACAlgorithm))
+	require.Equal(t, http.StatusUnauthorized, serve(h, "", sha256Base64))
+	require.True(t, errors.Is(lastErr, auth.ErrHMACInvalidSignature))
+	for _, alg := range auth.HMACAlgorithms() {
+		require.Equal(t, http.StatusOK, serve(h, alg.String(), alg), alg.String())
+	}
+	require.Equal(t, http.StatusOK, serve(h, "sha256", auth.HMACAlgorithm{
+		Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingHex}))
+
+	// header does not match the signature
+	require.Equal(t, http.StatusUnauthorized, serve(h, "sha384:base64", sha256Base64))
+	require.True(t, errors.Is(lastErr, auth.ErrHMACInvalidSignature))
+
+	// unknown algorithm
+	require.Equal(t, http.StatusUnauthorized, serve(h, "sha1024", sha256Base64))
+	require.True(t, errors.Is(lastErr, auth.ErrHMACUnsupportedAlgorithm))
+
+	// custom default algorithm
+	h = newHandler(observer, auth.WithHMACAlgorithm(sha256Base64))
+	require.Equal(t, http.StatusOK, serve(h, "", sha256Base64))
+	require.Equal(t, http.StatusUnauthorized, serve(h, "", auth.DefaultHMACAlgorithm))
+
+	// restricted algorithms
+	h = newHandler(observer, auth.WithHMACAllowedAlgorithms(sha256Base64))
+	require.Equal(t, http.StatusOK, serve(h, "sha256:base64", sha256Base64))
+	require.Equal(t, http.StatusOK, serve(h, "", auth.DefaultHMACAlgorithm))
+	require.Equal(t, http.StatusUnauthorized, serve(h, "sha512:hex", auth.DefaultHMACAlgorithm))
+	require.True(t, errors.Is(lastErr, auth.ErrHMACUnsupportedAlgorithm))
+	require.True(t, strings.Contains(lastErr.Error(), "not allowed"), lastErr.Error())
+
+	// negotiation disabled without algorithm header name
+	headers := auth.DefaultHMACHeaderNames
+	headers.Algorithm = ""
+	h = newHandler(observer, auth.WithHMACHeaderNames(headers))
+	require.Equal(t, http.StatusOK, serve(h, "sha256:base64", auth.DefaultHMACAlgorithm))
+}
+
+func TestHMACTransportAlgorithm(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+	sha384Base64URL := auth.HMACAlgorithm{Hash: auth.HMACHashSHA384, Encoding: auth.HMACEncodingBase64URL}
+
+	var header string
+	server := httptest.NewServer(auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACAllowedAlgorithms(sha384Base64URL),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(auth.HMACHeaderAlgorithm)
+	})))
+	defer server.Close()
+
+	client := &http.Client{Transport: &auth.HMACTransport{
+		AppID:     appID,
+		Secret:    secret,
+		Algorithm: sha384Base64URL,
+	}}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "sha384:base64url", header)
+
+	// no header without algorithm
+	client = &http.Client{Transport: auth.NewHMACTransport(appID, secret, nil)}
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, header)
+
+	// not allowed algorithm
+	client = &http.Client{Transport: &auth.HMACTransport{
+		AppID:     appID,
+		Secret:    secret,
+		Algorithm: auth.HMACAlgorithm{Hash: auth.HMACHashSHA256, Encoding: auth.HMACEncodingHex},
+	}}
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// algorithm without algorithm header name
+	headers := auth.DefaultHMACHeaderNames
+	headers.Algorithm = ""
+	client = &http.Client{Transport: &auth.HMACTransport{
+		AppID:     appID,
+		Secret:    secret,
+		Algorithm: sha384Base64URL,
+		Headers:   headers,
+	}}
+	_, err = client.Get(server.URL)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "algorithm header name")
+}
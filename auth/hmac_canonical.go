@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// HMACPayloadFunc builds the payload that is signed for a request with the
+// specified nonce and timestamp.
+type HMACPayloadFunc func(r *http.Request, nonce, timestamp string) ([]byte, error)
+
+// HMACLegacyPayload is the default HMACPayloadFunc which only covers the
+// concatenated nonce and timestamp. It is kept for existing partners, new
+// integrations should use HMACCanonicalPayload.
+func HMACLegacyPayload(r *http.Request, nonce, timestamp string) ([]byte, error) {
+	return []byte(nonce + timestamp), nil
+}
+
+// HMACCanonicalPayload returns an HMACPayloadFunc building a canonical
+// request which covers method, path, query, the specified headers, nonce,
+// timestamp and the SHA512 digest of the body. This binds a signature to a
+// single request so it can not be replayed against other endpoints or with
+// another body. See CanonicalHMACRequest for the exact format.
+func HMACCanonicalPayload(signedHeaders ...string) HMACPayloadFunc {
+	headers := canonicalHeaderNames(signedHeaders)
+	return func(r *http.Request, nonce, timestamp string) ([]byte, error) {
+		return canonicalHMACRequest(r, headers, nonce, timestamp)
+	}
+}
+
+// CanonicalHMACRequest returns the canonical request signed in canonical
+// mode. It consists of the following lines separated by "\n":
+//
+//	HTTP method in upper case
+//	escaped URL path ("/" if empty)
+//	query parameters sorted by key and value, URL-encoded and joined by "&"
+//	one "name:value" line per signed header (lower case names, sorted)
+//	signed header names joined by ";"
+//	nonce
+//	timestamp
+//	HEX( SHA512( body ) )
+//
+// Multiple values of the same header are joined by ",". The "host" header
+// is read from the request host. The request body is restored after reading.
+// Query parameters which can not be URL-decoded are returned as error.
+func CanonicalHMACRequest(
+	r *http.Request,
+	signedHeaders []string,
+	nonce, timestamp string,
+) ([]byte, error) {
+
+	return canonicalHMACRequest(
+		r, canonicalHeaderNames(signedHeaders), nonce, timestamp)
+}
+
+func canonicalHMACRequest(
+	r *http.Request,
+	headers []string,
+	nonce, timestamp string,
+) ([]byte, error) {
+
+	bodyDigest, err := hmacBodyDigest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString(strings.ToUpper(r.Method))
+	b.WriteByte('\n')
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+	b.WriteByte('\n')
+
+	query, err := canonicalQuery(r.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	b.WriteString(query)
+	b.WriteByte('\n')
+
+	for _, name := range headers {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(canonicalHeaderValue(r, name))
+		b.WriteByte('\n')
+	}
+	b.WriteString(strings.Join(headers, ";"))
+	b.WriteByte('\n')
+
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(bodyDigest)
+
+	return b.Bytes(), nil
+}
+
+// canonicalHeaderNames returns the lower cased, sorted and deduplicated
+// header names.
+func canonicalHeaderNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	headers := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	if name == "host" {
+		if r.Host != "" {
+			return r.Host
+		}
+		return r.URL.Host
+	}
+
+	values := r.Header.Values(name)
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// canonicalQuery returns the query parameters of the raw query sorted by key
+// and value. It is built from the raw query instead of url.Values, as
+// url.ParseQuery drops malformed parameters which would then not be signed,
+// and fails for those instead.
+func canonicalQuery(rawQuery string) (string, error) {
+	type param struct{ key, value string }
+
+	var params []param
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return "", errors.E(fmt.Errorf("invalid query parameter %q: %v", pair, err))
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return "", errors.E(fmt.Errorf("invalid query parameter %q: %v", pair, err))
+		}
+		params = append(params, param{key: key, value: value})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = url.QueryEscape(p.key) + "=" + url.QueryEscape(p.value)
+	}
+	return strings.Join(pairs, "&"), nil
+}
+
+// hmacBodyDigest returns the hex-encoded SHA512 digest of the request body
+// and replaces the body so it can be read again.
+func hmacBodyDigest(r *http.Request) (string, error) {
+	body, err := rereadableBody(r)
+	if err != nil {
+		return "", err
+	}
+	digest := sha512.Sum512(body)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// maxBodyReader limits the size of a request body read for authorization.
+type maxBodyReader struct {
+	io.ReadCloser
+	remaining int64
+	tooLong   bool // known length exceeds the limit
+	exceeded  bool // read more than the limit
+}
+
+// limitBody replaces the request body with a maxBodyReader limited to max
+// bytes. It returns nil if there is no body or max is not positive.
+func limitBody(r *http.Request, max int64) *maxBodyReader {
+	if max <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := &maxBodyReader{ReadCloser: r.Body, remaining: max, tooLong: r.ContentLength > max}
+	r.Body = body
+	return body
+}
+
+func (b *maxBodyReader) Read(p []byte) (int, error) {
+	// reject before reading if the length is known
+	if b.tooLong || b.exceeded {
+		b.exceeded = true
+		return 0, errors.E(fmt.Errorf("request body too large"))
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.exceeded = true
+	return n, errors.E(fmt.Errorf("request body too large"))
+}
+
+// rereadableBody reads the request body and replaces it so it can be read
+// again.
+func rereadableBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
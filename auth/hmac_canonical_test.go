@@ -0,0 +1,218 @@
+package auth_test
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCanonicalHMACRequest(t *testing.T) {
+	r := httptest.NewRequest(
+		"post", "http://example.com/some/path?b=2&a=3&a=1&c=x%20y",
+		strings.NewReader(`{"hello":"world"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Add("X-Custom", " one ")
+	r.Header.Add("X-Custom", "two")
+
+	payload, err := auth.CanonicalHMACRequest(
+		r, []string{"X-Custom", "content-type", "Host", "x-custom"}, "nonce", "1657670400")
+	require.NoError(t, err)
+	require.Equal(t,
+		"POST\n"+
+			"/some/path\n"+
+			"a=1&a=3&b=2&c=x+y\n"+
+			"content-type:application/json\n"+
+			"host:example.com\n"+
+			"x-custom:one,two\n"+
+			"content-type;host;x-custom\n"+
+			"nonce\n"+
+			"1657670400\n"+
+			sha512Hex(`{"hello":"world"}`),
+		string(payload))
+
+	// body can be read again
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"hello":"world"}`, string(body))
+
+	// parameters which url.ParseQuery drops are signed or rejected
+	r = httptest.NewRequest("GET", "http://example.com/?b=1&&a;x=2&a=", nil)
+	payload, err = auth.CanonicalHMACRequest(r, nil, "n", "1")
+	require.NoError(t, err)
+	require.Equal(t, "GET\n/\na=&a%3Bx=2&b=1\n\nn\n1\n"+sha512Hex(""), string(payload))
+	r.URL.RawQuery = "a=1&b=%zz"
+	_, err = auth.CanonicalHMACRequest(r, nil, "n", "1")
+	require.Error(t, err)
+
+	// empty path and body
+	r = httptest.NewRequest("GET", "http://example.com", nil)
+	r.URL.Path = ""
+	payload, err = auth.CanonicalHMACRequest(r, nil, "n", "1")
+	require.NoError(t, err)
+	require.Equal(t, "GET\n/\n\n\nn\n1\n"+sha512Hex(""), string(payload))
+}
+
+func TestHMACMiddlewareCanonicalPayload(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-app-secret")
+	nonceExpiration := 2 * time.Second
+	payload := auth.HMACCanonicalPayload("Content-Type")
+
+	hmacMiddleware := auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACPayload(payload),
+	)
+
+	handler := hmacMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// handler can still read the body
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		_, _ = w.Write(body)
+	}))
+
+	newSignedRequest := func() *http.Request {
+		r := httptest.NewRequest(
+			"POST", "http://service/orders?id=1", strings.NewReader(`{"a":1}`))
+		r.Header.Set("Content-Type", "application/json")
+		err := auth.HMACSignRequest(r, appID, secret,
+			uuid.NewString(), fmt.Sprintf("%d", time.Now().Unix()), payload)
+		require.NoError(t, err)
+		return r
+	}
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// happy path
+	w := serve(newSignedRequest())
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, `{"a":1}`, w.Body.String())
+
+	// other endpoint
+	r := newSignedRequest()
+	r.URL.Path = "/admin"
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// other query
+	r = newSignedRequest()
+	r.URL.RawQuery = "id=2"
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// malformed query parameters are not ignored
+	for _, param := range []string{"a=%zz", "a%zz=1", "a;b=1"} {
+		r = newSignedRequest()
+		r.URL.RawQuery += "&" + param
+		require.Equal(t, http.StatusUnauthorized, serve(r).Code, param)
+	}
+
+	// other method
+	r = newSignedRequest()
+	r.Method = "DELETE"
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// other body
+	r = newSignedRequest()
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"a":2}`))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// other signed header
+	r = newSignedRequest()
+	r.Header.Set("Content-Type", "text/plain")
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// legacy signature is not accepted in canonical mode
+	r = newSignedRequest()
+	nonce, timestamp := uuid.NewString(), fmt.Sprintf("%d", time.Now().Unix())
+	auth.SetHMACHeaders(r, appID, nonce, timestamp,
+		auth.HMACSign(secret, []byte(nonce+timestamp)))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+}
+
+func sha512Hex(s string) string {
+	sum := sha512.Sum512([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHMACMaxBodySize(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-app-secret")
+	payload := auth.HMACCanonicalPayload()
+	large := strings.Repeat("x", 17)
+
+	h := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{appID: secret}),
+		auth.WithHMACPayload(payload),
+		auth.WithHMACMaxBodySize(16),
+	)
+	newSignedRequest := func(body string, p auth.HMACPayloadFunc) *http.Request {
+		r := httptest.NewRequest("POST", "http://service/upload", strings.NewReader(body))
+		err := auth.HMACSignRequest(r, appID, secret,
+			uuid.NewString(), fmt.Sprintf("%d", time.Now().Unix()), p)
+		require.NoError(t, err)
+		return r
+	}
+
+	_, err := h.Authenticate(newSignedRequest(strings.Repeat("x", 16), payload))
+	require.NoError(t, err)
+
+	// known length is rejected before reading the body
+	r := newSignedRequest(large, payload)
+	body := &countingReader{Reader: strings.NewReader(large)}
+	r.Body = ioutil.NopCloser(body)
+	_, err = h.Authenticate(r)
+	require.True(t, errors.Is(err, auth.ErrHMACBodyTooLarge), err)
+	require.Equal(t, 0, body.n)
+
+	// unknown length is rejected after reading the limit
+	r = newSignedRequest(large, payload)
+	r.ContentLength = -1
+	_, err = h.Authenticate(r)
+	require.True(t, errors.Is(err, auth.ErrHMACBodyTooLarge), err)
+	reason, ok := auth.HMACFailureReason(err)
+	require.True(t, ok)
+	require.Equal(t, auth.ErrHMACBodyTooLarge, reason)
+
+	// bodies not covered by the payload are not limited
+	legacy := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{appID: secret}),
+		auth.WithHMACMaxBodySize(16),
+	)
+	r = newSignedRequest(large, auth.HMACLegacyPayload)
+	_, err = legacy.Authenticate(r)
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Equal(t, large, string(read))
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// HMACFailure is the machine-readable reason of a failed HMAC authorization.
+// The reasons are sentinel errors which can be checked with errors.Is on the
+// errors of HMACMiddleware.
+type HMACFailure string
+
+// HMAC authorization failure reasons.
+const (
+	ErrHMACMissingHeader        HMACFailure = "missing_header"
+	ErrHMACUnknownAppID         HMACFailure = "unknown_app_id"
+	ErrHMACReplayedNonce        HMACFailure = "replayed_nonce"
+	ErrHMACInvalidTimestamp     HMACFailure = "invalid_timestamp"
+	ErrHMACExpired              HMACFailure = "expired"
+	ErrHMACInvalidSignature     HMACFailure = "invalid_signature"
+	ErrHMACUnsupportedAlgorithm HMACFailure = "unsupported_algorithm"
+	ErrHMACBodyTooLarge         HMACFailure = "body_too_large"
+)
+
+func (f HMACFailure) Error() string {
+	return "hmac authorization failed: " + strings.Replace(string(f), "_", " ", -1)
+}
+
+// HMACObserver is called with the result of every HMAC authorization, err is
+// nil on success. The app ID is the unverified value of the request header.
+type HMACObserver func(r *http.Request, appID string, err error)
+
+// HMACFailureReason returns the reason of an HMAC authorization failure or
+// false if err is not one.
+func HMACFailureReason(err error) (HMACFailure, bool) {
+	var e *hmacError
+	if !errors.As(err, &e) {
+		return "", false
+	}
+	return e.reason, true
+}
+
+// hmacError is an HMAC authorization failure with a detailed message.
+type hmacError struct {
+	reason HMACFailure
+	err    error
+}
+
+func (e *hmacError) Error() string { return e.err.Error() }
+
+// Unwrap returns the detailed error.
+func (e *hmacError) Unwrap() error { return e.err }
+
+// Is reports whether target is the failure reason.
+func (e *hmacError) Is(target error) bool {
+	reason, ok := target.(HMACFailure)
+	return ok && reason == e.reason
+}
+
+// newHMACError returns an error having the reason and the formatted detailed
+// message.
+func newHMACError(reason HMACFailure, format string, args ...interface{}) error {
+	return &hmacError{reason: reason, err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,124 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/testhelpers"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHMACFailureReasons(t *testing.T) {
+	logUnsugared, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	log, logs := testhelpers.ObserveLogs(t, logUnsugared.Sugar())
+
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+
+	type observed struct {
+		appID string
+		err   error
+	}
+	var last observed
+	handler := auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return log.Desugar() },
+		auth.WithHMACObserver(func(r *http.Request, appID string, err error) {
+			last = observed{appID: appID, err: err}
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(appID, nonce, timestamp, signature string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp, signature)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	now := func() string { return fmt.Sprintf("%d", time.Now().Unix()) }
+	sign := func(nonce, timestamp string) string {
+		return auth.HMACSign(secret, []byte(nonce+timestamp))
+	}
+
+	requireFailure := func(reason auth.HMACFailure, status int) {
+		require.Equal(t, http.StatusUnauthorized, status)
+		require.Error(t, last.err)
+		require.True(t, errors.Is(last.err, reason), "%v", last.err)
+		require.True(t, errors.IsKind(errors.Unauthorized, last.err))
+
+		got, ok := auth.HMACFailureReason(last.err)
+		require.True(t, ok)
+		require.Equal(t, reason, got)
+
+		testhelpers.RequireLastLogEntry(t, logs, zapcore.ErrorLevel, "",
+			map[string]string{"hmac_failure": string(reason)})
+	}
+
+	// success
+	nonce, timestamp := uuid.NewString(), now()
+	require.Equal(t, http.StatusOK, serve(appID, nonce, timestamp, sign(nonce, timestamp)))
+	require.Equal(t, observed{appID: appID}, last)
+
+	requireFailure(auth.ErrHMACReplayedNonce,
+		serve(appID, nonce, timestamp, sign(nonce, timestamp)))
+
+	nonce, timestamp = uuid.NewString(), now()
+	requireFailure(auth.ErrHMACMissingHeader,
+		serve("", nonce, timestamp, sign(nonce, timestamp)))
+	requireFailure(auth.ErrHMACMissingHeader,
+		serve(appID, "", timestamp, sign("", timestamp)))
+	requireFailure(auth.ErrHMACMissingHeader,
+		serve(appID, nonce, "", sign(nonce, "")))
+	requireFailure(auth.ErrHMACMissingHeader,
+		serve(appID, nonce, timestamp, ""))
+
+	requireFailure(auth.ErrHMACUnknownAppID,
+		serve("other-app-id", nonce, timestamp, sign(nonce, timestamp)))
+	require.Equal(t, "other-app-id", last.appID)
+
+	requireFailure(auth.ErrHMACInvalidTimestamp,
+		serve(appID, nonce, "x", sign(nonce, "x")))
+
+	future := fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix())
+	requireFailure(auth.ErrHMACInvalidTimestamp,
+		serve(appID, nonce, future, sign(nonce, future)))
+
+	past := fmt.Sprintf("%d", time.Now().Add(-time.Minute).Unix())
+	requireFailure(auth.ErrHMACExpired,
+		serve(appID, nonce, past, sign(nonce, past)))
+
+	requireFailure(auth.ErrHMACInvalidSignature,
+		serve(appID, nonce, timestamp, sign(nonce, timestamp+"1")))
+
+	// other errors have no reason
+	_, ok := auth.HMACFailureReason(errors.E(errors.Unauthorized))
+	require.False(t, ok)
+}
+
+func TestHMACFailureError(t *testing.T) {
+	require.Equal(t, "hmac authorization failed: invalid signature",
+		auth.ErrHMACInvalidSignature.Error())
+	for _, reason := range []auth.HMACFailure{
+		auth.ErrHMACMissingHeader,
+		auth.ErrHMACUnknownAppID,
+		auth.ErrHMACReplayedNonce,
+		auth.ErrHMACInvalidTimestamp,
+		auth.ErrHMACExpired,
+	} {
+		require.False(t, strings.Contains(reason.Error(), "_"))
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HMACNonceStore is an interface abstracting away the storage of nonces used
+// for HMAC authorization which must be checked and recorded atomically, so
+// concurrent requests with the same nonce can not both pass.
+type HMACNonceStore interface {
+	// AddNonce records the key for ttl if it is not recorded yet and
+	// reports false if it is already recorded.
+	AddNonce(key string, ttl time.Duration) (bool, error)
+}
+
+// HMACNonceCacheAdder is implemented by caches having a set-if-absent Add
+// method returning an error if the key already exists, like go-cache.
+type HMACNonceCacheAdder interface {
+	Add(string, interface{}, time.Duration) error
+}
+
+// HMACNonceKey returns the key under which the nonce of the app ID is
+// recorded. Nonces are scoped per app ID so one app can not burn the nonces
+// of another one.
+func HMACNonceKey(appID, nonce string) string {
+	return url.QueryEscape(appID) + ":" + nonce
+}
+
+// NewHMACNonceCacheStore returns an HMACNonceStore recording nonces with the
+// Add method of the cache, e.g. a go-cache *cache.Cache.
+func NewHMACNonceCacheStore(c HMACNonceCacheAdder) HMACNonceStore {
+	return nonceCacheAdderStore{cache: c}
+}
+
+// nonceCacheAdderStore adapts an HMACNonceCacheAdder to an HMACNonceStore.
+type nonceCacheAdderStore struct {
+	cache HMACNonceCacheAdder
+}
+
+func (s nonceCacheAdderStore) AddNonce(key string, ttl time.Duration) (bool, error) {
+	// go-cache only fails if the key already exists
+	return s.cache.Add(key, struct{}{}, ttl) == nil, nil
+}
+
+// lockedNonceCache adapts an HMACNonceCache without Add to an HMACNonceStore
+// by guarding Get and Set with a lock. This is only atomic as long as the
+// cache is not shared with other middleware instances.
+type lockedNonceCache struct {
+	mu    sync.Mutex
+	cache HMACNonceCache
+}
+
+func (s *lockedNonceCache) AddNonce(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.cache.Get(key); found {
+		return false, nil
+	}
+	s.cache.Set(key, struct{}{}, ttl)
+	return true, nil
+}
+
+// newHMACNonceCacheStore returns an HMACNonceStore for the nonce cache
+// passed to HMACMiddleware.
+func newHMACNonceCacheStore(c HMACNonceCache) HMACNonceStore {
+	if adder, ok := c.(HMACNonceCacheAdder); ok {
+		return NewHMACNonceCacheStore(adder)
+	}
+	return &lockedNonceCache{cache: c}
+}
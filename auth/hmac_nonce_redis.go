@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// RedisNonceStore is an HMACNonceStore speaking the Redis protocol (RESP),
+// so replay protection works across all replicas of a service sharing the
+// same Redis (or compatible) server. Nonces are recorded with
+// "SET key 1 NX PX ttl" which is atomic on the server.
+//
+// Use NewRedisNonceStore for sensible defaults. It is safe for concurrent use
+// and keeps up to MaxIdleConns idle connections open.
+type RedisNonceStore struct {
+	// Addr is the "host:port" address of the server.
+	Addr string
+	// Password is sent with AUTH if not empty.
+	Password string
+	// DB is selected with SELECT if not 0.
+	DB int
+	// KeyPrefix is prepended to every nonce key.
+	KeyPrefix string
+	// Timeout is used for connecting and for every command.
+	Timeout time.Duration
+	// MaxIdleConns is the maximum number of idle connections kept open.
+	MaxIdleConns int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// NewRedisNonceStore returns a new RedisNonceStore for the server at addr
+// with the key prefix "hmac-nonce:", a timeout of 5 seconds and up to 10 idle
+// connections.
+func NewRedisNonceStore(addr string) *RedisNonceStore {
+	return &RedisNonceStore{
+		Addr:         addr,
+		KeyPrefix:    "hmac-nonce:",
+		Timeout:      5 * time.Second,
+		MaxIdleConns: 10,
+	}
+}
+
+// AddNonce records the key with "SET key 1 NX PX ttl" and reports false if
+// the key already exists. If an idle connection was closed by the server,
+// e.g. after its idle timeout or a failover, the command is retried once on
+// a new connection.
+func (s *RedisNonceStore) AddNonce(key string, ttl time.Duration) (bool, error) {
+	ttlMillis := int64(ttl / time.Millisecond)
+	if ttlMillis < 1 {
+		ttlMillis = 1
+	}
+	args := []string{"SET", s.KeyPrefix + key, "1",
+		"NX", "PX", strconv.FormatInt(ttlMillis, 10)}
+
+	c, idle, err := s.conn()
+	if err != nil {
+		return false, errors.E(err)
+	}
+
+	reply, err := c.do(args...)
+	if err != nil && idle && isConnClosed(err) {
+		c.Close()
+		if c, err = s.dial(); err != nil {
+			return false, errors.E(err)
+		}
+		reply, err = c.do(args...)
+	}
+	if err != nil {
+		c.Close()
+		return false, errors.E(err)
+	}
+	s.release(c)
+
+	// OK if set, null if the key already exists
+	return reply != nil, nil
+}
+
+// Close closes all idle connections.
+func (s *RedisNonceStore) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+
+	var err error
+	for _, c := range idle {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// conn returns an idle connection or dials a new one and reports whether
+// the connection was idle.
+func (s *RedisNonceStore) conn() (*redisConn, bool, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, true, nil
+	}
+	s.mu.Unlock()
+
+	c, err := s.dial()
+	return c, false, err
+}
+
+// dial connects to the server, authenticates and selects the database.
+func (s *RedisNonceStore) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{
+		Conn:    netConn,
+		r:       bufio.NewReader(netConn),
+		timeout: s.Timeout,
+	}
+
+	if s.Password != "" {
+		if _, err := c.do("AUTH", s.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(s.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// release puts the connection back to the idle connections or closes it.
+func (s *RedisNonceStore) release(c *redisConn) {
+	s.mu.Lock()
+	if len(s.idle) < s.MaxIdleConns {
+		s.idle = append(s.idle, c)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	c.Close()
+}
+
+// isConnClosed reports whether err is an I/O error of a connection closed by
+// the server. Timeouts are not included, as the server may still execute the
+// command.
+func isConnClosed(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+// do sends a command and returns its reply, which is nil for null replies.
+// Error replies are returned as errors.
+func (c *redisConn) do(args ...string) ([]byte, error) {
+	if c.timeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := make([]byte, 0, 64)
+	cmd = append(cmd, '*')
+	cmd = strconv.AppendInt(cmd, int64(len(args)), 10)
+	cmd = append(cmd, '\r', '\n')
+	for _, arg := range args {
+		cmd = append(cmd, '$')
+		cmd = strconv.AppendInt(cmd, int64(len(arg)), 10)
+		cmd = append(cmd, '\r', '\n')
+		cmd = append(cmd, arg...)
+		cmd = append(cmd, '\r', '\n')
+	}
+	if _, err := c.Write(cmd); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() ([]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return append([]byte{}, line[1:]...), nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (c *redisConn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
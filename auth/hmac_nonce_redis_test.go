@@ -0,0 +1,277 @@
+package auth_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRedisNonceStore(t *testing.T) {
+	server := newRESPServer(t, "")
+	defer server.Close()
+
+	store := auth.NewRedisNonceStore(server.Addr())
+	defer store.Close()
+	requireNonceStore(t, store)
+
+	// keys are prefixed
+	added, err := store.AddNonce("key", time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.True(t, server.Has(0, "hmac-nonce:key"))
+}
+
+func TestRedisNonceStoreAuthAndDB(t *testing.T) {
+	server := newRESPServer(t, "some-password")
+	defer server.Close()
+
+	// wrong password
+	store := auth.NewRedisNonceStore(server.Addr())
+	store.Password = "wrong-password"
+	_, err := store.AddNonce("key", time.Minute)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "WRONGPASS")
+
+	store.Password = "some-password"
+	store.DB = 3
+	store.KeyPrefix = ""
+	added, err := store.AddNonce("key", time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.True(t, server.Has(3, "key"))
+	require.False(t, server.Has(0, "key"))
+	require.NoError(t, store.Close())
+
+	// server not reachable
+	server.Close()
+	store = auth.NewRedisNonceStore(server.Addr())
+	store.Timeout = time.Second
+	_, err = store.AddNonce("key", time.Minute)
+	require.Error(t, err)
+}
+
+func TestRedisNonceStoreClosedConn(t *testing.T) {
+	server := newRESPServer(t, "")
+	defer server.Close()
+
+	store := auth.NewRedisNonceStore(server.Addr())
+	defer store.Close()
+
+	added, err := store.AddNonce("key1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// the idle connection is closed by the server and the command retried
+	server.CloseConns()
+	added, err = store.AddNonce("key2", time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.True(t, server.Has(0, "hmac-nonce:key2"))
+
+	// the new connection is kept idle
+	added, err = store.AddNonce("key2", time.Minute)
+	require.NoError(t, err)
+	require.False(t, added)
+}
+
+func TestHMACMiddlewareRedisNonceStoreReplicas(t *testing.T) {
+	server := newRESPServer(t, "")
+	defer server.Close()
+
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+
+	// two replicas sharing the same store
+	var replicas []http.Handler
+	for i := 0; i < 2; i++ {
+		store := auth.NewRedisNonceStore(server.Addr())
+		defer store.Close()
+		replicas = append(replicas, auth.HMACMiddleware(
+			map[string][]byte{"some-app": secret},
+			nil,
+			nonceExpiration,
+			func(r *http.Request) *zap.Logger { return zap.NewNop() },
+			auth.WithHMACNonceStore(store),
+		)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	}
+
+	nonce := uuid.NewString()
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	signature := auth.HMACSign(secret, []byte(nonce+timestamp))
+	for i, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, "some-app", nonce, timestamp, signature)
+		w := httptest.NewRecorder()
+		replicas[i].ServeHTTP(w, r)
+		require.Equal(t, status, w.Code)
+	}
+}
+
+// respServer is a minimal in-process server speaking the Redis protocol
+// supporting AUTH, SELECT and SET with NX and PX.
+type respServer struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+
+	mu    sync.Mutex
+	data  map[int]map[string]time.Time
+	conns map[net.Conn]struct{}
+}
+
+func newRESPServer(t *testing.T, password string) *respServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &respServer{
+		t:        t,
+		listener: l,
+		password: password,
+		data:     make(map[int]map[string]time.Time),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *respServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *respServer) Close() {
+	s.listener.Close()
+}
+
+// CloseConns closes all open connections, like a server closing idle
+// connections after its timeout.
+func (s *respServer) CloseConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Has reports whether the not expired key exists in the database.
+func (s *respServer) Has(db int, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.data[db][key]
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (s *respServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *respServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	db := 0
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			reply = "+OK\r\n"
+		case cmd == "SET" && len(args) == 6 &&
+			strings.ToUpper(args[3]) == "NX" && strings.ToUpper(args[4]) == "PX":
+			ttl, _ := strconv.Atoi(args[5])
+			reply = s.setNX(db, args[1], time.Duration(ttl)*time.Millisecond)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *respServer) setNX(db int, key string, ttl time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data[db] == nil {
+		s.data[db] = make(map[string]time.Time)
+	}
+	if expiresAt, ok := s.data[db][key]; ok && time.Now().Before(expiresAt) {
+		return "$-1\r\n"
+	}
+	s.data[db][key] = time.Now().Add(ttl)
+	return "+OK\r\n"
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array header %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk header %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// SQLNonceStore is an HMACNonceStore recording nonces in a database table
+// through database/sql, so replay protection works across all replicas of a
+// service sharing the same database. The table needs a unique nonce key and
+// the expiration as unix milliseconds:
+//
+//	CREATE TABLE hmac_nonces (
+//	    nonce_key  VARCHAR(255) NOT NULL PRIMARY KEY,
+//	    expires_at BIGINT       NOT NULL
+//	);
+//
+// Expired nonces are replaced when the same key is recorded again, use
+// DeleteExpired for periodically removing the others.
+type SQLNonceStore struct {
+	// DB is the database holding the table.
+	DB *sql.DB
+	// Table is the name of the table.
+	Table string
+	// Placeholder returns the n-th (starting at 1) query parameter
+	// placeholder, e.g. "?" for MySQL and SQLite or "$1" for PostgreSQL.
+	Placeholder func(n int) string
+	// Timeout is used for every AddNonce call if greater than 0.
+	Timeout time.Duration
+}
+
+// NewSQLNonceStore returns a new SQLNonceStore for the table in db using "?"
+// as query parameter placeholders and a timeout of 5 seconds.
+func NewSQLNonceStore(db *sql.DB, table string) *SQLNonceStore {
+	return &SQLNonceStore{
+		DB:          db,
+		Table:       table,
+		Placeholder: SQLPlaceholderQuestion,
+		Timeout:     5 * time.Second,
+	}
+}
+
+// SQLPlaceholderQuestion returns "?" placeholders used e.g. by MySQL and SQLite.
+func SQLPlaceholderQuestion(n int) string {
+	return "?"
+}
+
+// SQLPlaceholderDollar returns "$n" placeholders used e.g. by PostgreSQL.
+func SQLPlaceholderDollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// AddNonce inserts the key unless a not expired row exists for it, relying on
+// the unique constraint of the nonce key for concurrent inserts.
+func (s *SQLNonceStore) AddNonce(key string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE nonce_key = %s AND expires_at <= %s",
+		s.Table, s.placeholder(1), s.placeholder(2)),
+		key, unixMillis(now))
+	if err != nil {
+		return false, errors.E(err)
+	}
+
+	_, insertErr := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (nonce_key, expires_at) VALUES (%s, %s)",
+		s.Table, s.placeholder(1), s.placeholder(2)),
+		key, unixMillis(expiresAt))
+	if insertErr == nil {
+		return true, nil
+	}
+
+	// the insert failed, check if it is because of an existing nonce
+	var count int
+	err = s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s WHERE nonce_key = %s",
+		s.Table, s.placeholder(1)),
+		key).Scan(&count)
+	if err != nil {
+		return false, errors.E(err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+	return false, errors.E(insertErr)
+}
+
+// DeleteExpired deletes all expired nonces and returns their number.
+func (s *SQLNonceStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE expires_at <= %s",
+		s.Table, s.placeholder(1)),
+		unixMillis(time.Now()))
+	if err != nil {
+		return 0, errors.E(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.E(err)
+	}
+	return n, nil
+}
+
+func (s *SQLNonceStore) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return SQLPlaceholderQuestion(n)
+	}
+	return s.Placeholder(n)
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,224 @@
+package auth_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLNonceStore(t *testing.T) {
+	db, fake := openFakeNonceDB(t, "?")
+	defer db.Close()
+
+	store := auth.NewSQLNonceStore(db, "hmac_nonces")
+	requireNonceStore(t, store)
+
+	// expired nonces are deleted
+	fake.insert("expired", time.Now().Add(-time.Second))
+	n, err := store.DeleteExpired(context.Background())
+	require.NoError(t, err)
+	require.True(t, n >= 1)
+	require.False(t, fake.has("expired"))
+
+	// other insert errors are returned
+	fake.failInserts(true)
+	_, err = store.AddNonce("key-failing", time.Minute)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "some insert error")
+	fake.failInserts(false)
+}
+
+func TestSQLNonceStoreDollarPlaceholder(t *testing.T) {
+	db, _ := openFakeNonceDB(t, "$")
+	defer db.Close()
+
+	store := auth.NewSQLNonceStore(db, "hmac_nonces")
+	store.Placeholder = auth.SQLPlaceholderDollar
+	requireNonceStore(t, store)
+}
+
+// fakeNonceDriver is a database/sql driver for in-memory databases holding a
+// nonce table with a unique nonce key, understanding only the queries of
+// SQLNonceStore.
+type fakeNonceDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeNonceDB
+}
+
+var fakeDriver = &fakeNonceDriver{dbs: make(map[string]*fakeNonceDB)}
+
+func init() {
+	sql.Register("fakenonce", fakeDriver)
+}
+
+func openFakeNonceDB(t *testing.T, placeholder string) (*sql.DB, *fakeNonceDB) {
+	fake := &fakeNonceDB{
+		placeholder: placeholder,
+		rows:        make(map[string]int64),
+	}
+	dsn := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+
+	fakeDriver.mu.Lock()
+	fakeDriver.dbs[dsn] = fake
+	fakeDriver.mu.Unlock()
+
+	db, err := sql.Open("fakenonce", dsn)
+	require.NoError(t, err)
+	return db, fake
+}
+
+func (d *fakeNonceDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	db, ok := d.dbs[dsn]
+	if !ok {
+		return nil, fmt.Errorf("unknown database %s", dsn)
+	}
+	return &fakeNonceConn{db: db}, nil
+}
+
+type fakeNonceDB struct {
+	placeholder string
+
+	mu          sync.Mutex
+	rows        map[string]int64
+	insertsFail bool
+}
+
+func (db *fakeNonceDB) insert(key string, expiresAt time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rows[key] = expiresAt.UnixNano() / int64(time.Millisecond)
+}
+
+func (db *fakeNonceDB) has(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, ok := db.rows[key]
+	return ok
+}
+
+func (db *fakeNonceDB) failInserts(fail bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.insertsFail = fail
+}
+
+// query executes one of the SQLNonceStore queries.
+func (db *fakeNonceDB) query(query string, args []driver.Value) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	p1, p2 := "?", "?"
+	if db.placeholder == "$" {
+		p1, p2 = "$1", "$2"
+	}
+
+	switch query {
+	case "DELETE FROM hmac_nonces WHERE nonce_key = " + p1 + " AND expires_at <= " + p2:
+		key, now := args[0].(string), args[1].(int64)
+		if expiresAt, ok := db.rows[key]; ok && expiresAt <= now {
+			delete(db.rows, key)
+			return 1, nil
+		}
+		return 0, nil
+
+	case "DELETE FROM hmac_nonces WHERE expires_at <= " + p1:
+		now := args[0].(int64)
+		var n int64
+		for key, expiresAt := range db.rows {
+			if expiresAt <= now {
+				delete(db.rows, key)
+				n++
+			}
+		}
+		return n, nil
+
+	case "INSERT INTO hmac_nonces (nonce_key, expires_at) VALUES (" + p1 + ", " + p2 + ")":
+		if db.insertsFail {
+			return 0, fmt.Errorf("some insert error")
+		}
+		key, expiresAt := args[0].(string), args[1].(int64)
+		if _, ok := db.rows[key]; ok {
+			return 0, fmt.Errorf("unique constraint violation")
+		}
+		db.rows[key] = expiresAt
+		return 1, nil
+
+	case "SELECT COUNT(*) FROM hmac_nonces WHERE nonce_key = " + p1:
+		if _, ok := db.rows[args[0].(string)]; ok {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unsupported query: %s", query)
+}
+
+type fakeNonceConn struct {
+	db *fakeNonceDB
+}
+
+func (c *fakeNonceConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeNonceStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeNonceConn) Close() error { return nil }
+
+func (c *fakeNonceConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+type fakeNonceStmt struct {
+	db    *fakeNonceDB
+	query string
+}
+
+func (s *fakeNonceStmt) Close() error  { return nil }
+func (s *fakeNonceStmt) NumInput() int { return -1 }
+
+func (s *fakeNonceStmt) Exec(args []driver.Value) (driver.Result, error) {
+	n, err := s.db.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *fakeNonceStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.query, "SELECT") {
+		return nil, fmt.Errorf("unsupported query: %s", s.query)
+	}
+	n, err := s.db.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeNonceRows{count: n}, nil
+}
+
+// fakeNonceRows returns a single row with a count.
+type fakeNonceRows struct {
+	count int64
+	done  bool
+}
+
+func (r *fakeNonceRows) Columns() []string { return []string{"count"} }
+func (r *fakeNonceRows) Close() error      { return nil }
+
+func (r *fakeNonceRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.count
+	return nil
+}
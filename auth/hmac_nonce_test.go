@@ -0,0 +1,201 @@
+package auth_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHMACNonceKey(t *testing.T) {
+	require.Equal(t, "some-app:nonce", auth.HMACNonceKey("some-app", "nonce"))
+	require.NotEqual(t,
+		auth.HMACNonceKey("a:b", "c"), auth.HMACNonceKey("a", "b:c"))
+}
+
+func TestHMACNonceCacheStore(t *testing.T) {
+	requireNonceStore(t, auth.NewHMACNonceCacheStore(cache.New(time.Minute, time.Minute)))
+}
+
+// requireNonceStore tests that the store records nonces atomically and that
+// nonces can be added again once they expired.
+func requireNonceStore(t *testing.T, store auth.HMACNonceStore) {
+	key := auth.HMACNonceKey("some-app", uuid.NewString())
+
+	added, err := store.AddNonce(key, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = store.AddNonce(key, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, added)
+
+	// other keys are independent
+	added, err = store.AddNonce(auth.HMACNonceKey("other-app", key), time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// expired nonces can be added again
+	time.Sleep(100 * time.Millisecond)
+	added, err = store.AddNonce(key, time.Minute)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// concurrent adds of the same key
+	key = auth.HMACNonceKey("some-app", uuid.NewString())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	addedCount := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			added, err := store.AddNonce(key, time.Minute)
+			if err != nil {
+				t.Error(err)
+			}
+			if added {
+				mu.Lock()
+				addedCount++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, addedCount)
+}
+
+func TestHMACMiddlewareNoncePerAppID(t *testing.T) {
+	secrets := map[string][]byte{
+		"some-app":  []byte("some-secret"),
+		"other-app": []byte("other-secret"),
+	}
+	nonceExpiration := 2 * time.Second
+	handler := auth.HMACMiddleware(
+		secrets,
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(appID, nonce string) int {
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp,
+			auth.HMACSign(secrets[appID], []byte(nonce+timestamp)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	nonce := uuid.NewString()
+	require.Equal(t, http.StatusOK, serve("some-app", nonce))
+	require.Equal(t, http.StatusUnauthorized, serve("some-app", nonce))
+	// same nonce of another app ID is fine
+	require.Equal(t, http.StatusOK, serve("other-app", nonce))
+	require.Equal(t, http.StatusUnauthorized, serve("other-app", nonce))
+
+	// nonce is not burnt by requests with an invalid signature
+	nonce = uuid.NewString()
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	r := httptest.NewRequest("GET", "/", nil)
+	auth.SetHMACHeaders(r, "some-app", nonce, timestamp,
+		auth.HMACSign(secrets["other-app"], []byte(nonce+timestamp)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, http.StatusOK, serve("some-app", nonce))
+}
+
+func TestHMACMiddlewareNonceStoreError(t *testing.T) {
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+	handler := auth.HMACMiddleware(
+		map[string][]byte{"some-app": secret},
+		nil,
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACNonceStore(failingNonceStore{}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	nonce := uuid.NewString()
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	r := httptest.NewRequest("GET", "/", nil)
+	auth.SetHMACHeaders(r, "some-app", nonce, timestamp,
+		auth.HMACSign(secret, []byte(nonce+timestamp)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestHMACMiddlewareConcurrentNonce(t *testing.T) {
+	secret := []byte("some-secret")
+	nonceExpiration := 2 * time.Second
+
+	nonceCaches := map[string]auth.HMACNonceCache{
+		// atomic Add of go-cache
+		"go-cache": cache.New(nonceExpiration, nonceExpiration),
+		// Get and Set guarded by the middleware
+		"get-set": &recordingNonceCache{
+			cache: cache.New(nonceExpiration, nonceExpiration),
+		},
+	}
+
+	for name, nonceCache := range nonceCaches {
+		t.Run(name, func(t *testing.T) {
+			handler := auth.HMACMiddleware(
+				map[string][]byte{"some-app": secret},
+				nonceCache,
+				nonceExpiration,
+				func(r *http.Request) *zap.Logger { return zap.NewNop() },
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for round := 0; round < 20; round++ {
+				nonce := uuid.NewString()
+				timestamp := fmt.Sprintf("%d", time.Now().Unix())
+				signature := auth.HMACSign(secret, []byte(nonce+timestamp))
+
+				var mu sync.Mutex
+				var wg sync.WaitGroup
+				statuses := make(map[int]int)
+				start := make(chan struct{})
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						r := httptest.NewRequest("GET", "/", nil)
+						auth.SetHMACHeaders(r, "some-app", nonce, timestamp, signature)
+						w := httptest.NewRecorder()
+						<-start
+						handler.ServeHTTP(w, r)
+						mu.Lock()
+						statuses[w.Code]++
+						mu.Unlock()
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				require.Equal(t, map[int]int{
+					http.StatusOK:           1,
+					http.StatusUnauthorized: 49,
+				}, statuses)
+			}
+		})
+	}
+}
+
+type failingNonceStore struct{}
+
+func (failingNonceStore) AddNonce(string, time.Duration) (bool, error) {
+	return false, errors.New("some store error")
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"hash"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HMACOption configures an HMAC created by NewHMAC or HMACMiddleware.
+type HMACOption func(*HMAC)
+
+// WithHMACSecrets sets a static secret per app ID.
+func WithHMACSecrets(secretsPerAppIDs map[string][]byte) HMACOption {
+	return WithHMACSecretProvider(SecretMap(secretsPerAppIDs))
+}
+
+// WithHMACSecretProvider sets the provider used for looking up the secrets of
+// app IDs, replacing the secretsPerAppIDs map passed to HMACMiddleware.
+// Signatures made with any of the currently valid secrets are accepted.
+func WithHMACSecretProvider(secrets SecretProvider) HMACOption {
+	return func(h *HMAC) {
+		h.secrets = secrets
+	}
+}
+
+// WithHMACNonceExpiration sets the duration for which signature timestamps
+// are valid and nonces must be unique. Defaults to DefaultHMACNonceExpiration.
+func WithHMACNonceExpiration(nonceExpiration time.Duration) HMACOption {
+	return func(h *HMAC) {
+		h.nonceExpiration = nonceExpiration
+	}
+}
+
+// WithHMACNonceCache sets the cache used for recording nonces, see
+// HMACNonceCache. A nil cache is ignored.
+func WithHMACNonceCache(nonceCache HMACNonceCache) HMACOption {
+	return func(h *HMAC) {
+		if nonceCache != nil {
+			h.nonces = newHMACNonceCacheStore(nonceCache)
+		}
+	}
+}
+
+// WithHMACNonceStore sets the store used for atomically recording nonces,
+// replacing the nonceCache passed to HMACMiddleware.
+func WithHMACNonceStore(nonces HMACNonceStore) HMACOption {
+	return func(h *HMAC) {
+		h.nonces = nonces
+	}
+}
+
+// WithHMACPayload sets the function building the signed payload, e.g.
+// HMACCanonicalPayload for signing the full request.
+// Defaults to HMACLegacyPayload (nonce+timestamp).
+func WithHMACPayload(payload HMACPayloadFunc) HMACOption {
+	return func(h *HMAC) {
+		h.payload = payload
+	}
+}
+
+// WithHMACAlgorithm sets the algorithm of signatures sent without the
+// algorithm header. Defaults to DefaultHMACAlgorithm.
+func WithHMACAlgorithm(alg HMACAlgorithm) HMACOption {
+	return func(h *HMAC) {
+		h.algorithm = alg
+	}
+}
+
+// WithHMACAllowedAlgorithms restricts the algorithms clients can negotiate
+// with the algorithm header. Defaults to all HMACAlgorithms.
+func WithHMACAllowedAlgorithms(algs ...HMACAlgorithm) HMACOption {
+	return func(h *HMAC) {
+		h.algorithms = algs
+	}
+}
+
+// WithHMACHash sets a custom hash function of signatures sent without the
+// algorithm header, taking precedence over the hash of WithHMACAlgorithm.
+// Defaults to sha512.New.
+func WithHMACHash(newHash func() hash.Hash) HMACOption {
+	return func(h *HMAC) {
+		h.newHash = newHash
+	}
+}
+
+// WithHMACHeaderNames sets the names of the request headers.
+// Defaults to DefaultHMACHeaderNames.
+func WithHMACHeaderNames(headers HMACHeaderNames) HMACOption {
+	return func(h *HMAC) {
+		h.headers = headers
+	}
+}
+
+// WithHMACMessageSignatures enables verifying HTTP message signatures
+// (RFC 9421) of requests having a Signature-Input header, in addition to the
+// X-Auth-* headers. The keyid parameter is the app ID. hmac-sha256 signatures
+// are verified with the secrets of the app ID, ed25519 signatures with its
+// public key. Signatures must have the created and nonce parameters and
+// cover the components, DefaultMessageSignatureComponents if none are
+// specified, and content-digest if the request has a body.
+func WithHMACMessageSignatures(
+	publicKeys map[string]ed25519.PublicKey,
+	components ...string,
+) HMACOption {
+
+	return func(h *HMAC) {
+		h.messageSignatures = true
+		h.messageKeys = publicKeys
+		if len(components) > 0 {
+			h.messageComponents = components
+		}
+	}
+}
+
+// WithHMACScopes sets the scopes granted to each app ID. They are added to the
+// authenticated HMACPrincipal and checked by RequireScope.
+func WithHMACScopes(scopesPerAppIDs map[string][]string) HMACOption {
+	return func(h *HMAC) {
+		h.scopes = scopesPerAppIDs
+	}
+}
+
+// WithHMACMaxBodySize sets the maximum size in bytes of request bodies read
+// for authorization, i.e. covered by the payload or a content digest. Larger
+// bodies are rejected with ErrHMACBodyTooLarge before they are hashed. As
+// such bodies are buffered, the limit also applies to next handlers.
+// Defaults to DefaultHMACMaxBodySize, 0 disables the limit.
+func WithHMACMaxBodySize(max int64) HMACOption {
+	return func(h *HMAC) {
+		h.maxBodySize = max
+	}
+}
+
+// WithHMACClockSkew sets the allowed clock difference between client and
+// server. Timestamps up to skew in the future are accepted and the maximum
+// age of timestamps is extended by skew. Defaults to 0.
+func WithHMACClockSkew(skew time.Duration) HMACOption {
+	return func(h *HMAC) {
+		h.skew = skew
+	}
+}
+
+// WithHMACClock sets the function returning the current time, useful for
+// testing. Defaults to time.Now.
+func WithHMACClock(now func() time.Time) HMACOption {
+	return func(h *HMAC) {
+		h.now = now
+	}
+}
+
+// WithHMACObserver sets a function called with the result of every
+// authorization, e.g. for counting failures per app ID and reason.
+func WithHMACObserver(observer HMACObserver) HMACOption {
+	return func(h *HMAC) {
+		h.observer = observer
+	}
+}
+
+// WithHMACRequestLogger sets the function returning the logger of a request
+// used by the default error handler. Without it failures are not logged.
+func WithHMACRequestLogger(requestLogger func(r *http.Request) *zap.Logger) HMACOption {
+	return func(h *HMAC) {
+		h.requestLogger = requestLogger
+	}
+}
+
+// WithHMACErrorHandler sets the function handling authorization failures,
+// e.g. for using another logger or error response format. Defaults to
+// respond.JSONError with the logger of WithHMACRequestLogger.
+func WithHMACErrorHandler(
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error),
+) HMACOption {
+
+	return func(h *HMAC) {
+		h.errorHandler = errorHandler
+	}
+}
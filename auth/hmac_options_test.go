@@ -0,0 +1,106 @@
+package auth_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHMAC(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	headers := auth.HMACHeaderNames{
+		AppID:     "X-Partner",
+		Nonce:     "X-Partner-Nonce",
+		Timestamp: "X-Partner-Time",
+		Signature: "X-Partner-Signature",
+	}
+
+	now := time.Now()
+	var handledErr error
+	h := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{appID: secret}),
+		auth.WithHMACNonceExpiration(time.Minute),
+		auth.WithHMACHash(sha256.New),
+		auth.WithHMACHeaderNames(headers),
+		auth.WithHMACClock(func() time.Time { return now }),
+		auth.WithHMACErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handledErr = err
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	)
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID, ok := auth.AppIDFromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(appID))
+	}))
+
+	// signed through a transport with the same settings
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := &http.Client{Transport: &auth.HMACTransport{
+		AppID:   appID,
+		Secret:  secret,
+		Hash:    sha256.New,
+		Headers: headers,
+	}}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// default headers and hash are not accepted
+	client = &http.Client{Transport: auth.NewHMACTransport(appID, secret, nil)}
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTeapot, resp.StatusCode)
+	require.True(t, errors.Is(handledErr, auth.ErrHMACMissingHeader))
+
+	// nonce expiration and clock are used
+	nonce := uuid.NewString()
+	timestamp := fmt.Sprintf("%d", now.Add(-2*time.Minute).Unix())
+	r := httptest.NewRequest("GET", "/", nil)
+	headers.Set(r, appID, nonce, timestamp, "x")
+	p, err := h.Authenticate(r)
+	require.Nil(t, p)
+	require.True(t, errors.Is(err, auth.ErrHMACExpired))
+}
+
+func TestNewHMACDefaults(t *testing.T) {
+	// no app ID is known
+	h := auth.NewHMAC()
+	r := httptest.NewRequest("GET", "/", nil)
+	auth.SetHMACHeaders(r, "some-app-id", "nonce", "1", "signature")
+	w := httptest.NewRecorder()
+	h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `{"msg":"invalid authorization"}`, w.Body.String())
+
+	// nonces are recorded in an in-process cache
+	secret := []byte("some-secret")
+	h = auth.NewHMAC(auth.WithHMACSecrets(map[string][]byte{"some-app-id": secret}))
+	nonce := uuid.NewString()
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	signature := auth.HMACSign(secret, []byte(nonce+timestamp))
+	for _, replayed := range []bool{false, true} {
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, "some-app-id", nonce, timestamp, signature)
+		p, err := h.Authenticate(r)
+		if replayed {
+			require.True(t, errors.Is(err, auth.ErrHMACReplayedNonce))
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, "some-app-id", p.AppID)
+		}
+	}
+}
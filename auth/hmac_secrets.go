@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// HMACSecret is a shared secret of an app ID.
+type HMACSecret struct {
+	// Version identifies the secret during rotation, e.g. "2022-07".
+	Version string
+	// Key is the shared secret itself.
+	Key []byte
+	// Expires is the time after which the secret is no longer accepted.
+	// The zero value means the secret never expires.
+	Expires time.Time
+}
+
+// active reports whether the secret is valid at the specified time.
+func (s HMACSecret) active(now time.Time) bool {
+	return s.Expires.IsZero() || now.Before(s.Expires)
+}
+
+// SecretProvider is an interface abstracting away the lookup of shared
+// secrets used for HMAC authorization.
+type SecretProvider interface {
+	// Secrets returns the currently valid secrets of the app ID or false
+	// if the app ID is unknown. During key rotation more than one secret
+	// is valid, a signature made with any of them is accepted.
+	Secrets(appID string) ([]HMACSecret, bool)
+}
+
+// SecretMap is a static SecretProvider having a single secret per app ID.
+type SecretMap map[string][]byte
+
+// Secrets returns the secret of the app ID.
+func (m SecretMap) Secrets(appID string) ([]HMACSecret, bool) {
+	secret, ok := m[appID]
+	if !ok {
+		return nil, false
+	}
+	return []HMACSecret{{Key: secret}}, true
+}
+
+// MemorySecretProvider is an in-memory SecretProvider which is safe for
+// concurrent use and supports overlapping old and new secrets for rotating
+// them without breaking in-flight clients.
+type MemorySecretProvider struct {
+	mu      sync.RWMutex
+	secrets map[string][]HMACSecret
+}
+
+// NewMemorySecretProvider returns a new empty MemorySecretProvider.
+func NewMemorySecretProvider() *MemorySecretProvider {
+	return &MemorySecretProvider{
+		secrets: make(map[string][]HMACSecret),
+	}
+}
+
+// Secrets returns the secrets of the app ID which are not expired.
+func (p *MemorySecretProvider) Secrets(appID string) ([]HMACSecret, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secrets, ok := p.secrets[appID]
+	if !ok {
+		return nil, false
+	}
+	return activeSecrets(secrets, time.Now()), true
+}
+
+// SetSecrets replaces all secrets of the app ID. Secrets with an empty key
+// are rejected.
+func (p *MemorySecretProvider) SetSecrets(appID string, secrets ...HMACSecret) error {
+	for _, s := range secrets {
+		if err := checkSecretKey(appID, s); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.secrets[appID] = append([]HMACSecret(nil), secrets...)
+	return nil
+}
+
+// AddSecret adds a new secret to the app ID while keeping the existing ones
+// valid, which starts a rotation. A secret with the same version is replaced.
+// Secrets with an empty key are rejected.
+func (p *MemorySecretProvider) AddSecret(appID string, secret HMACSecret) error {
+	if err := checkSecretKey(appID, secret); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secrets := []HMACSecret{secret}
+	for _, s := range p.secrets[appID] {
+		if s.Version != secret.Version {
+			secrets = append(secrets, s)
+		}
+	}
+	p.secrets[appID] = secrets
+	return nil
+}
+
+// checkSecretKey returns an error if the key of the secret is empty, as an
+// empty key would accept signatures made without knowing a secret.
+func checkSecretKey(appID string, secret HMACSecret) error {
+	if len(secret.Key) == 0 {
+		return errors.E(fmt.Errorf("empty secret version '%s' for app ID '%s'",
+			secret.Version, appID))
+	}
+	return nil
+}
+
+// RemoveSecret removes the secret with the specified version from the app ID,
+// which finishes a rotation. The app ID stays known.
+func (p *MemorySecretProvider) RemoveSecret(appID string, version string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secrets, ok := p.secrets[appID]
+	if !ok {
+		return
+	}
+	kept := make([]HMACSecret, 0, len(secrets))
+	for _, s := range secrets {
+		if s.Version != version {
+			kept = append(kept, s)
+		}
+	}
+	p.secrets[appID] = kept
+}
+
+// DeleteAppID removes the app ID and all its secrets.
+func (p *MemorySecretProvider) DeleteAppID(appID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.secrets, appID)
+}
+
+// FileSecretProvider is a SecretProvider reading the secrets from a JSON file
+// and reloading it when it changes, so secrets can be rotated without a
+// redeploy. The file maps app IDs to their secrets:
+//
+//	{
+//	  "some-app-id": [
+//	    {"version": "2", "secret": "new-secret"},
+//	    {"version": "1", "secret": "old-secret", "expires": "2022-08-01T00:00:00Z"}
+//	  ]
+//	}
+type FileSecretProvider struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu        sync.RWMutex
+	secrets   map[string][]HMACSecret
+	digest    [sha256.Size]byte
+	checkedAt time.Time
+	err       error
+}
+
+// fileSecret is a secret entry of the secrets file.
+type fileSecret struct {
+	Version string    `json:"version"`
+	Secret  string    `json:"secret"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileSecretProvider loads the secrets from the file at path and returns
+// a new FileSecretProvider which reads the file and compares its content for
+// changes at most once per reloadInterval when secrets are looked up.
+func NewFileSecretProvider(
+	path string,
+	reloadInterval time.Duration,
+) (*FileSecretProvider, error) {
+
+	p := &FileSecretProvider{
+		path:           path,
+		reloadInterval: reloadInterval,
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Secrets returns the secrets of the app ID which are not expired, reloading
+// the file first if it changed. If reloading fails the previously loaded
+// secrets are kept and the error is available through Err.
+func (p *FileSecretProvider) Secrets(appID string) ([]HMACSecret, bool) {
+	now := time.Now()
+	p.reloadIfChanged(now)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secrets, ok := p.secrets[appID]
+	if !ok {
+		return nil, false
+	}
+	return activeSecrets(secrets, now), true
+}
+
+// Reload reads the secrets file, replacing the loaded secrets on success.
+func (p *FileSecretProvider) Reload() error {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return p.setErr(errors.E(err))
+	}
+	return p.load(data, time.Now())
+}
+
+// Err returns the error of the last failed reload or nil if the last reload
+// succeeded.
+func (p *FileSecretProvider) Err() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.err
+}
+
+func (p *FileSecretProvider) reloadIfChanged(now time.Time) {
+	p.mu.Lock()
+	if now.Sub(p.checkedAt) < p.reloadInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.checkedAt = now
+	p.mu.Unlock()
+
+	// the content is compared, as a rotation does not necessarily change
+	// the size or the modification time within its resolution
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		p.setErr(errors.E(err))
+		return
+	}
+
+	p.mu.RLock()
+	changed := sha256.Sum256(data) != p.digest
+	p.mu.RUnlock()
+
+	if changed {
+		_ = p.load(data, now)
+	}
+}
+
+func (p *FileSecretProvider) load(data []byte, now time.Time) error {
+	var file map[string][]fileSecret
+	if err := json.Unmarshal(data, &file); err != nil {
+		return p.setErr(errors.E(
+			fmt.Errorf("invalid secrets file %s: %v", p.path, err)))
+	}
+
+	secrets := make(map[string][]HMACSecret, len(file))
+	for appID, entries := range file {
+		appSecrets := make([]HMACSecret, 0, len(entries))
+		for _, e := range entries {
+			if e.Secret == "" {
+				return p.setErr(errors.E(fmt.Errorf(
+					"invalid secrets file %s: empty secret for app ID '%s'",
+					p.path, appID)))
+			}
+			appSecrets = append(appSecrets, HMACSecret{
+				Version: e.Version,
+				Key:     []byte(e.Secret),
+				Expires: e.Expires,
+			})
+		}
+		secrets[appID] = appSecrets
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.secrets = secrets
+	p.digest = sha256.Sum256(data)
+	p.checkedAt = now
+	p.err = nil
+	return nil
+}
+
+func (p *FileSecretProvider) setErr(err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+	return err
+}
+
+// activeSecrets returns the secrets which are valid at the specified time.
+func activeSecrets(secrets []HMACSecret, now time.Time) []HMACSecret {
+	active := make([]HMACSecret, 0, len(secrets))
+	for _, s := range secrets {
+		if s.active(now) {
+			active = append(active, s)
+		}
+	}
+	return active
+}
@@ -0,0 +1,193 @@
+package auth_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSecretMap(t *testing.T) {
+	secrets := auth.SecretMap{"some-app-id": []byte("some-secret")}
+
+	got, ok := secrets.Secrets("some-app-id")
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{{Key: []byte("some-secret")}}, got)
+
+	_, ok = secrets.Secrets("unknown")
+	require.False(t, ok)
+}
+
+func TestMemorySecretProvider(t *testing.T) {
+	appID := "some-app-id"
+	oldSecret := auth.HMACSecret{Version: "1", Key: []byte("old-secret")}
+	newSecret := auth.HMACSecret{Version: "2", Key: []byte("new-secret")}
+
+	provider := auth.NewMemorySecretProvider()
+	require.NoError(t, provider.SetSecrets(appID, oldSecret))
+
+	nonceExpiration := 2 * time.Second
+	handler := auth.HMACMiddleware(
+		nil,
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACSecretProvider(provider),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	requireStatus := func(status int, secret []byte) {
+		nonce := uuid.NewString()
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp,
+			auth.HMACSign(secret, []byte(nonce+timestamp)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, status, w.Code, w.Body.String())
+	}
+
+	requireStatus(http.StatusOK, oldSecret.Key)
+	requireStatus(http.StatusUnauthorized, newSecret.Key)
+
+	// rotation started: both secrets are valid
+	require.NoError(t, provider.AddSecret(appID, newSecret))
+	secrets, ok := provider.Secrets(appID)
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{newSecret, oldSecret}, secrets)
+	requireStatus(http.StatusOK, oldSecret.Key)
+	requireStatus(http.StatusOK, newSecret.Key)
+
+	// rotation finished: only the new secret is valid
+	provider.RemoveSecret(appID, oldSecret.Version)
+	requireStatus(http.StatusUnauthorized, oldSecret.Key)
+	requireStatus(http.StatusOK, newSecret.Key)
+
+	// expired secrets are not valid anymore
+	expiredSecret := auth.HMACSecret{
+		Version: "0",
+		Key:     []byte("expired-secret"),
+		Expires: time.Now().Add(-time.Second),
+	}
+	require.NoError(t, provider.AddSecret(appID, expiredSecret))
+	secrets, ok = provider.Secrets(appID)
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{newSecret}, secrets)
+	requireStatus(http.StatusUnauthorized, expiredSecret.Key)
+
+	// empty keys are rejected
+	emptySecret := auth.HMACSecret{Version: "3"}
+	require.Error(t, provider.AddSecret(appID, emptySecret))
+	require.Error(t, provider.SetSecrets(appID, newSecret, emptySecret))
+	secrets, ok = provider.Secrets(appID)
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{newSecret}, secrets)
+	requireStatus(http.StatusUnauthorized, nil)
+
+	// app ID is removed
+	provider.DeleteAppID(appID)
+	_, ok = provider.Secrets(appID)
+	require.False(t, ok)
+	requireStatus(http.StatusUnauthorized, newSecret.Key)
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+
+	modTime := time.Now()
+	writeFile := func(content string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		// make sure the change is detected on file systems with a
+		// coarse modification time resolution
+		modTime = modTime.Add(time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	// missing file
+	_, err = auth.NewFileSecretProvider(path, 0)
+	require.Error(t, err)
+
+	// invalid file
+	writeFile(`{"some-app-id": [{"version": "1"}]}`)
+	_, err = auth.NewFileSecretProvider(path, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "empty secret for app ID 'some-app-id'")
+
+	writeFile(`{"some-app-id": [{"version": "1", "secret": "old-secret"}]}`)
+	provider, err := auth.NewFileSecretProvider(path, 0)
+	require.NoError(t, err)
+
+	secrets, ok := provider.Secrets("some-app-id")
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{
+		{Version: "1", Key: []byte("old-secret")},
+	}, secrets)
+	_, ok = provider.Secrets("other-app-id")
+	require.False(t, ok)
+
+	// file changes are picked up
+	writeFile(`{
+		"some-app-id": [
+			{"version": "2", "secret": "new-secret"},
+			{"version": "1", "secret": "old-secret", "expires": "2000-01-01T00:00:00Z"}
+		],
+		"other-app-id": [{"version": "1", "secret": "other-secret"}]
+	}`)
+	secrets, ok = provider.Secrets("some-app-id")
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{
+		{Version: "2", Key: []byte("new-secret")},
+	}, secrets)
+	_, ok = provider.Secrets("other-app-id")
+	require.True(t, ok)
+	require.NoError(t, provider.Err())
+
+	// broken file keeps the loaded secrets
+	writeFile(`{broken`)
+	_, ok = provider.Secrets("other-app-id")
+	require.True(t, ok)
+	require.Error(t, provider.Err())
+	require.Error(t, provider.Reload())
+
+	// fixed file is loaded again
+	writeFile(`{"some-app-id": [{"version": "3", "secret": "newest-secret"}]}`)
+	_, ok = provider.Secrets("other-app-id")
+	require.False(t, ok)
+	require.NoError(t, provider.Err())
+
+	// changes of the same size and modification time are picked up
+	writeFile(`{"some-app-id": [{"version": "4", "secret": "rotated-secret"}]}`)
+	_, ok = provider.Secrets("some-app-id")
+	require.True(t, ok)
+	require.NoError(t, provider.Err())
+	require.NoError(t, ioutil.WriteFile(path,
+		[]byte(`{"some-app-id": [{"version": "5", "secret": "rotated-secre2"}]}`), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	secrets, ok = provider.Secrets("some-app-id")
+	require.True(t, ok)
+	require.Equal(t, []auth.HMACSecret{
+		{Version: "5", Key: []byte("rotated-secre2")},
+	}, secrets)
+
+	// changes are not picked up within the reload interval
+	provider, err = auth.NewFileSecretProvider(path, time.Hour)
+	require.NoError(t, err)
+	writeFile(`{"other-app-id": [{"version": "1", "secret": "other-secret"}]}`)
+	_, ok = provider.Secrets("some-app-id")
+	require.True(t, ok)
+	require.NoError(t, provider.Reload())
+	_, ok = provider.Secrets("some-app-id")
+	require.False(t, ok)
+}
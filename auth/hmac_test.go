@@ -62,7 +62,7 @@ func TestHMACMiddleware(t *testing.T) {
 
 	requireNonceCache := func(nonceCacheSize int, nonceMustBeFoundInCache bool) {
 		require.Equal(t, nonceCacheSize, nonceCache.ItemCount())
-		_, nonceFoundInCache := nonceCache.Get(nonce)
+		_, nonceFoundInCache := nonceCache.Get(auth.HMACNonceKey(appID, nonce))
 		require.Equal(t, nonceMustBeFoundInCache, nonceFoundInCache)
 	}
 
@@ -218,3 +218,81 @@ func TestSetAndGetHMACHeaders(t *testing.T) {
 	require.Equal(t, timestamp, gotTimestamp)
 	require.Equal(t, signature, gotSignature)
 }
+
+func TestHMACMiddlewareClockSkew(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-app-secret")
+	nonceExpiration := 2 * time.Minute
+	skew := 30 * time.Second
+
+	now := time.Unix(1657670400, 0)
+	nonceCache := &recordingNonceCache{cache: cache.New(time.Hour, time.Hour)}
+	handler := auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		nonceCache,
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACClockSkew(skew),
+		auth.WithHMACClock(func() time.Time { return now }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(nonce string, ts time.Time) int {
+		timestamp := fmt.Sprintf("%d", ts.Unix())
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp,
+			auth.HMACSign(secret, []byte(nonce+timestamp)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// in the future within skew
+	require.Equal(t, http.StatusOK, serve(uuid.NewString(), now.Add(skew)))
+	// nonce is cached until the timestamp is older than expiration plus skew
+	require.Equal(t, nonceExpiration+2*skew, nonceCache.lastTTL)
+
+	// in the future beyond skew
+	require.Equal(t, http.StatusUnauthorized,
+		serve(uuid.NewString(), now.Add(skew+time.Second)))
+
+	// old within expiration plus skew
+	require.Equal(t, http.StatusOK,
+		serve(uuid.NewString(), now.Add(-nonceExpiration-skew)))
+	require.Equal(t, time.Millisecond, nonceCache.lastTTL)
+
+	require.Equal(t, http.StatusOK,
+		serve(uuid.NewString(), now.Add(-nonceExpiration)))
+	require.Equal(t, skew, nonceCache.lastTTL)
+
+	// older than expiration plus skew
+	require.Equal(t, http.StatusUnauthorized,
+		serve(uuid.NewString(), now.Add(-nonceExpiration-skew-time.Second)))
+
+	// without skew future timestamps are rejected
+	handler = auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		nonceCache,
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACClock(func() time.Time { return now }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	require.Equal(t, http.StatusOK, serve(uuid.NewString(), now))
+	require.Equal(t, nonceExpiration, nonceCache.lastTTL)
+	require.Equal(t, http.StatusUnauthorized,
+		serve(uuid.NewString(), now.Add(time.Second)))
+}
+
+// recordingNonceCache records the TTL of the last cached nonce.
+type recordingNonceCache struct {
+	cache   *cache.Cache
+	lastTTL time.Duration
+}
+
+func (c *recordingNonceCache) Get(k string) (interface{}, bool) {
+	return c.cache.Get(k)
+}
+
+func (c *recordingNonceCache) Set(k string, v interface{}, ttl time.Duration) {
+	c.lastTTL = ttl
+	c.cache.Set(k, v, ttl)
+}
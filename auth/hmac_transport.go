@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/errors"
+)
+
+// HMACTransport is an http.RoundTripper which signs every outgoing request
+// with a new UUID nonce, the current unix timestamp and the HMAC signature
+// expected by HMACMiddleware.
+//
+//	client := &http.Client{Transport: auth.NewHMACTransport(appID, secret, nil)}
+type HMACTransport struct {
+	// AppID is sent in the X-Auth-App-ID header.
+	AppID string
+	// Secret is the shared secret configured for AppID on the server.
+	Secret []byte
+	// Payload builds the signed payload and must match the payload function
+	// of the server. Defaults to HMACLegacyPayload if nil.
+	Payload HMACPayloadFunc
+	// Algorithm is the hash function and encoding of the signature. It is
+	// sent in the X-Auth-Algorithm header if set, otherwise the signature is
+	// made with DefaultHMACAlgorithm and the server default is assumed.
+	Algorithm HMACAlgorithm
+	// Hash is a custom hash function of the signature and must match the
+	// one of the server. It takes precedence over the hash of Algorithm.
+	Hash func() hash.Hash
+	// Headers are the names of the request headers. Empty names default to
+	// the ones of DefaultHMACHeaderNames, except for the Algorithm header
+	// which is only sent if Headers is empty or names it.
+	Headers HMACHeaderNames
+	// Base is the underlying RoundTripper. Defaults to
+	// http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// NewHMACTransport returns a new HMACTransport signing requests in legacy
+// mode for the specified app ID and secret and sending them with base.
+func NewHMACTransport(
+	appID string,
+	secret []byte,
+	base http.RoundTripper,
+) *HMACTransport {
+
+	return &HMACTransport{
+		AppID:  appID,
+		Secret: secret,
+		Base:   base,
+	}
+}
+
+// RoundTrip signs a copy of the request and sends it with the base
+// RoundTripper. The original request is not modified.
+func (t *HMACTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	payload := t.Payload
+	if payload == nil {
+		payload = HMACLegacyPayload
+	}
+	headers := t.headers()
+
+	signed := r.Clone(r.Context())
+	nonce := uuid.NewString()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	err := t.sign(signed, headers, nonce, timestamp, payload)
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+
+	return t.base().RoundTrip(signed)
+}
+
+func (t *HMACTransport) sign(
+	r *http.Request,
+	headers HMACHeaderNames,
+	nonce, timestamp string,
+	payload HMACPayloadFunc,
+) error {
+
+	alg := t.Algorithm
+	negotiate := alg != (HMACAlgorithm{})
+	if !negotiate {
+		alg = DefaultHMACAlgorithm
+	} else if headers.Algorithm == "" {
+		return errors.E(fmt.Errorf("HMAC algorithm '%s' needs an algorithm header name", alg))
+	}
+	newHash := t.Hash
+	if newHash == nil {
+		var err error
+		if newHash, err = alg.newHash(); err != nil {
+			return err
+		}
+	}
+
+	p, err := payload(r, nonce, timestamp)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(newHash, t.Secret)
+	mac.Write(p)
+
+	headers.Set(r, t.AppID, nonce, timestamp, alg.encode(mac.Sum(nil)))
+	if negotiate {
+		r.Header.Set(headers.Algorithm, alg.String())
+	}
+	return nil
+}
+
+// headers returns the header names with the empty names of Headers set to
+// the defaults.
+func (t *HMACTransport) headers() HMACHeaderNames {
+	if t.Headers == (HMACHeaderNames{}) {
+		return DefaultHMACHeaderNames
+	}
+
+	headers := t.Headers
+	if headers.AppID == "" {
+		headers.AppID = DefaultHMACHeaderNames.AppID
+	}
+	if headers.Nonce == "" {
+		headers.Nonce = DefaultHMACHeaderNames.Nonce
+	}
+	if headers.Timestamp == "" {
+		headers.Timestamp = DefaultHMACHeaderNames.Timestamp
+	}
+	if headers.Signature == "" {
+		headers.Signature = DefaultHMACHeaderNames.Signature
+	}
+	return headers
+}
+
+func (t *HMACTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
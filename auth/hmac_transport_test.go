@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHMACTransport(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-app-secret")
+	nonceExpiration := 2 * time.Second
+
+	newServer := func(opts ...auth.HMACOption) *httptest.Server {
+		hmacMiddleware := auth.HMACMiddleware(
+			map[string][]byte{appID: secret},
+			cache.New(nonceExpiration, nonceExpiration),
+			nonceExpiration,
+			func(r *http.Request) *zap.Logger { return zap.NewNop() },
+			opts...,
+		)
+		return httptest.NewServer(hmacMiddleware(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				_, _ = w.Write(body)
+			})))
+	}
+
+	// legacy mode, every request gets a new nonce
+	server := newServer()
+	defer server.Close()
+
+	client := &http.Client{Transport: auth.NewHMACTransport(appID, secret, nil)}
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// original request is not modified
+		require.Empty(t, req.Header.Get(auth.HMACHeaderSignature))
+	}
+
+	// wrong secret
+	client = &http.Client{Transport: auth.NewHMACTransport(appID, []byte("x"), nil)}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// canonical mode with body
+	payload := auth.HMACCanonicalPayload("Content-Type")
+	canonicalServer := newServer(auth.WithHMACPayload(payload))
+	defer canonicalServer.Close()
+
+	client = &http.Client{Transport: &auth.HMACTransport{
+		AppID:   appID,
+		Secret:  secret,
+		Payload: payload,
+		Base:    http.DefaultTransport,
+	}}
+	resp, err = client.Post(
+		canonicalServer.URL+"/orders?id=1", "application/json",
+		strings.NewReader(`{"a":1}`))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"a":1}`, string(body))
+
+	// payload errors are returned
+	req, err := http.NewRequest("POST", canonicalServer.URL, ioutil.NopCloser(errReader{}))
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "some read error")
+
+	// empty header names default to the X-Auth-* headers
+	headers := auth.DefaultHMACHeaderNames
+	headers.Signature = "X-Signature"
+	headersServer := newServer(auth.WithHMACHeaderNames(headers))
+	defer headersServer.Close()
+
+	client = &http.Client{Transport: &auth.HMACTransport{
+		AppID:   appID,
+		Secret:  secret,
+		Headers: auth.HMACHeaderNames{Signature: "X-Signature"},
+	}}
+	resp, err = client.Get(headersServer.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("some read error")
+}
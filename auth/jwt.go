@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/respond"
+	"go.uber.org/zap"
+)
+
+// JWTClaims are the claims of a verified JWT.
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// Claims are all claims of the token including the registered ones
+	// above, numbers are json.Number.
+	Claims map[string]interface{}
+}
+
+// PrincipalID returns the subject of the token.
+func (c *JWTClaims) PrincipalID() string {
+	return c.Subject
+}
+
+// HasScope reports whether the scope is granted to the token by its
+// space-delimited scope claim or its scp claim, a string or array.
+func (c *JWTClaims) HasScope(scope string) bool {
+	for _, name := range []string{"scope", "scp"} {
+		switch v := c.Claims[name].(type) {
+		case string:
+			if containsString(strings.Fields(v), scope) {
+				return true
+			}
+		case []interface{}:
+			for _, s := range v {
+				if s == scope {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// JWT authorizes HTTP requests by verifying the JWT bearer token of the
+// Authorization header. It is safe for concurrent use.
+type JWT struct {
+	keys          JWTKeySet
+	algorithms    []string
+	issuer        string
+	audience      []string
+	skew          time.Duration
+	now           func() time.Time
+	requestLogger func(r *http.Request) *zap.Logger
+	errorHandler  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewJWT returns a new JWT configured by the options. Without options no
+// key is known, HS256, RS256 and ES256 signatures are accepted, issuer and
+// audience are not checked and failures are responded with respond.JSONError
+// without logging.
+func NewJWT(opts ...JWTOption) *JWT {
+	j := &JWT{
+		keys: StaticJWTKeys(nil),
+		algorithms: []string{
+			JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256,
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.errorHandler == nil {
+		j.errorHandler = j.respondError
+	}
+	return j
+}
+
+// JWTMiddleware verifies the bearer token of the Authorization header with
+// the keys. Tokens must have a valid signature and an exp claim, exp and nbf
+// are validated with the clock skew of WithJWTClockSkew, iss and aud if
+// WithJWTIssuer and WithJWTAudience are used. Failures are responded as
+// errors.Unauthorized with respond.JSONError. The claims are available to
+// next handlers through JWTClaimsFromContext.
+// It is a shorthand for NewJWT with the corresponding options, which are
+// applied before opts.
+func JWTMiddleware(
+	keys JWTKeySet,
+	requestLogger func(r *http.Request) *zap.Logger,
+	opts ...JWTOption,
+) func(next http.Handler) http.Handler {
+
+	opts = append([]JWTOption{
+		WithJWTKeys(keys),
+		WithJWTRequestLogger(requestLogger),
+	}, opts...)
+
+	return NewJWT(opts...).Middleware
+}
+
+// Middleware returns an HTTP middleware passing only authorized requests to
+// next, with the JWTClaims in the request context. Failures are handled by
+// the error handler.
+func (j *JWT) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := j.Authenticate(r)
+		if err != nil {
+			j.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithJWTClaims(r.Context(), claims)))
+	})
+}
+
+// Authenticate verifies the bearer token of the request and returns its
+// claims. Failures are returned as errors.Unauthorized.
+func (j *JWT) Authenticate(r *http.Request) (*JWTClaims, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		err := fmt.Errorf("invalid authorization: request header Authorization " +
+			"is missing or not a bearer token")
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+
+	claims, err := j.Verify(token)
+	if err != nil {
+		return nil, errors.E(err, errors.Unauthorized, "invalid authorization")
+	}
+	return claims, nil
+}
+
+// Verify verifies the signature and claims of the token and returns the
+// claims.
+func (j *JWT) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.E(fmt.Errorf("invalid token: expected 3 parts, got %d", len(parts)))
+	}
+
+	var header struct {
+		Alg  string   `json:"alg"`
+		Kid  string   `json:"kid"`
+		Crit []string `json:"crit"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.E(fmt.Errorf("invalid token header: %v", err))
+	}
+	if !containsString(j.algorithms, header.Alg) {
+		return nil, errors.E(fmt.Errorf("invalid token: algorithm '%s' is not allowed", header.Alg))
+	}
+	if len(header.Crit) > 0 {
+		return nil, errors.E(fmt.Errorf("invalid token: critical headers %v are not supported", header.Crit))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.E(fmt.Errorf("invalid token signature: %v", err))
+	}
+	if err := j.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJWTPart(parts[1], &raw); err != nil {
+		return nil, errors.E(fmt.Errorf("invalid token claims: %v", err))
+	}
+	claims, err := newJWTClaims(raw)
+	if err != nil {
+		return nil, errors.E(fmt.Errorf("invalid token claims: %v", err))
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// respondError is the default error handler responding with
+// respond.JSONError.
+func (j *JWT) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var log *zap.Logger
+	if j.requestLogger != nil {
+		log = j.requestLogger(r)
+	}
+	// no error code if the request has no token (RFC 6750, section 3.1)
+	challenge := "Bearer"
+	if _, ok := bearerToken(r); ok {
+		challenge = `Bearer error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	respond.JSONError(w, log, err)
+}
+
+// bearerToken returns the token of the Authorization header and reports
+// whether the header has a bearer token.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+func (j *JWT) verifySignature(alg, kid, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	keys := j.keys.Keys(kid)
+	for _, k := range keys {
+		if k.algorithm() != alg {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(signed))
+			if hmac.Equal(signature, mac.Sum(nil)) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if len(signature) == 64 {
+				r := new(big.Int).SetBytes(signature[:32])
+				s := new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(key, digest[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.E(fmt.Errorf("invalid token signature: no %s key with kid '%s' matches", alg, kid))
+}
+
+func (j *JWT) validate(c *JWTClaims) error {
+	now := j.now()
+	if c.ExpiresAt.IsZero() {
+		return errors.E(fmt.Errorf("invalid token: claim exp is missing"))
+	}
+	if now.After(c.ExpiresAt.Add(j.skew)) {
+		return errors.E(fmt.Errorf("invalid token: expired at '%s', more than clock skew %s ago",
+			c.ExpiresAt, j.skew))
+	}
+	if !c.NotBefore.IsZero() && now.Add(j.skew).Before(c.NotBefore) {
+		return errors.E(fmt.Errorf("invalid token: not valid before '%s' with clock skew %s",
+			c.NotBefore, j.skew))
+	}
+	if j.issuer != "" && c.Issuer != j.issuer {
+		return errors.E(fmt.Errorf("invalid token: issuer '%s' is not '%s'", c.Issuer, j.issuer))
+	}
+	if len(j.audience) > 0 {
+		for _, aud := range c.Audience {
+			if containsString(j.audience, aud) {
+				return nil
+			}
+		}
+		return errors.E(fmt.Errorf("invalid token: audience %v does not contain any of %v",
+			c.Audience, j.audience))
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// maxJWTNumericDate is the largest absolute value of exp, nbf and iat claims
+// in seconds, the largest integer exactly representable as float64.
+const maxJWTNumericDate = 1 << 53
+
+// newJWTClaims returns the claims with the registered claims of raw.
+func newJWTClaims(raw map[string]interface{}) (*JWTClaims, error) {
+	c := &JWTClaims{Claims: raw}
+
+	for name, dst := range map[string]*string{
+		"iss": &c.Issuer,
+		"sub": &c.Subject,
+		"jti": &c.ID,
+	} {
+		if v, ok := raw[name]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %s is not a string", name)
+			}
+			*dst = s
+		}
+	}
+
+	switch aud := raw["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim aud is not a string or array of strings")
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		return nil, fmt.Errorf("claim aud is not a string or array of strings")
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"exp": &c.ExpiresAt,
+		"nbf": &c.NotBefore,
+		"iat": &c.IssuedAt,
+	} {
+		v, ok := raw[name]
+		if !ok {
+			continue
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("claim %s is not a number", name)
+		}
+		f, err := n.Float64()
+		// int64 conversions of larger numbers depend on the platform
+		if err != nil || math.Abs(f) > maxJWTNumericDate {
+			return nil, fmt.Errorf("claim %s is not a valid number", name)
+		}
+		sec, frac := math.Modf(f)
+		*dst = time.Unix(int64(sec), int64(frac*1e9))
+	}
+	return c, nil
+}
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// JWT signature algorithms.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+)
+
+// JWTKey is a key for verifying JWT signatures.
+type JWTKey struct {
+	// ID is matched against the kid header of tokens. Keys without ID
+	// match all tokens.
+	ID string
+	// Algorithm is the signature algorithm the key is used for. Defaults to
+	// HS256 for []byte, RS256 for *rsa.PublicKey and ES256 for
+	// *ecdsa.PublicKey keys.
+	Algorithm string
+	// Key is a []byte secret, an *rsa.PublicKey or an *ecdsa.PublicKey on
+	// curve P-256.
+	Key interface{}
+}
+
+// algorithm returns the algorithm of the key or an empty string if the key
+// type does not match its algorithm.
+func (k JWTKey) algorithm() string {
+	alg := k.Algorithm
+	var keyAlg string
+	switch key := k.Key.(type) {
+	case []byte:
+		keyAlg = JWTAlgorithmHS256
+	case *rsa.PublicKey:
+		keyAlg = JWTAlgorithmRS256
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			keyAlg = JWTAlgorithmES256
+		}
+	}
+	if alg == "" || alg == keyAlg {
+		return keyAlg
+	}
+	return ""
+}
+
+// JWTKeySet provides the keys for verifying JWT signatures.
+type JWTKeySet interface {
+	// Keys returns the keys matching the key ID of a token, which is empty
+	// if the token has no kid header.
+	Keys(kid string) []JWTKey
+}
+
+// StaticJWTKeys is a JWTKeySet of fixed keys.
+type StaticJWTKeys []JWTKey
+
+// Keys returns the keys having the key ID or no ID. All keys are returned
+// if kid is empty.
+func (s StaticJWTKeys) Keys(kid string) []JWTKey {
+	return matchingJWTKeys(s, kid)
+}
+
+func matchingJWTKeys(keys []JWTKey, kid string) []JWTKey {
+	if kid == "" {
+		return keys
+	}
+	matching := make([]JWTKey, 0, 1)
+	for _, k := range keys {
+		if k.ID == "" || k.ID == kid {
+			matching = append(matching, k)
+		}
+	}
+	return matching
+}
+
+// JWKS is a JWTKeySet loaded from a JSON Web Key Set document (RFC 7517) in
+// a file or at a URL, e.g. of the identity provider. The document is loaded
+// again when it is older than the refresh interval, or when a token has an
+// unknown key ID and the last load was at least a second ago, so rotated keys
+// are picked up. If loading fails the previously loaded keys are kept and the
+// error is available through Err.
+type JWKS struct {
+	source          string
+	read            func() ([]byte, error)
+	refreshInterval time.Duration
+
+	// refreshMu is held while loading, so the key set is loaded only once
+	// if it is due for concurrent requests.
+	refreshMu sync.Mutex
+
+	mu       sync.RWMutex
+	keys     []JWTKey
+	loadedAt time.Time
+	err      error
+}
+
+// NewJWKSFile loads the JSON Web Key Set from the file at path and returns a
+// new JWKS loading it again after refreshInterval.
+func NewJWKSFile(path string, refreshInterval time.Duration) (*JWKS, error) {
+	return newJWKS(path, refreshInterval, func() ([]byte, error) {
+		return ioutil.ReadFile(path)
+	})
+}
+
+// NewJWKSURL loads the JSON Web Key Set from the URL and returns a new JWKS
+// loading it again after refreshInterval. The client defaults to an
+// http.Client with a timeout of 10 seconds if nil.
+func NewJWKSURL(url string, refreshInterval time.Duration, client *http.Client) (*JWKS, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return newJWKS(url, refreshInterval, func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	})
+}
+
+func newJWKS(
+	source string,
+	refreshInterval time.Duration,
+	read func() ([]byte, error),
+) (*JWKS, error) {
+
+	s := &JWKS{
+		source:          source,
+		read:            read,
+		refreshInterval: refreshInterval,
+	}
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Keys returns the keys having the key ID, loading the key set again first
+// if it is due.
+func (s *JWKS) Keys(kid string) []JWTKey {
+	keys, due := s.matchingKeys(kid)
+	if !due {
+		return keys
+	}
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	// loaded by a concurrent request meanwhile
+	if keys, due = s.matchingKeys(kid); due {
+		if err := s.refresh(); err == nil {
+			keys, _ = s.matchingKeys(kid)
+		}
+	}
+	return keys
+}
+
+// matchingKeys returns the keys having the key ID and reports whether the key
+// set is due for loading again.
+func (s *JWKS) matchingKeys(kid string) ([]JWTKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := matchingJWTKeys(s.keys, kid)
+	age := time.Since(s.loadedAt)
+	return keys, age >= s.refreshInterval || (len(keys) == 0 && age >= time.Second)
+}
+
+// Refresh loads the key set, replacing the loaded keys on success.
+func (s *JWKS) Refresh() error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	return s.refresh()
+}
+
+func (s *JWKS) refresh() error {
+	data, err := s.read()
+	if err == nil {
+		var keys []JWTKey
+		if keys, err = ParseJWKS(data); err == nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.keys = keys
+			s.loadedAt = time.Now()
+			s.err = nil
+			return nil
+		}
+	}
+
+	err = errors.E(fmt.Errorf("loading JWKS %s: %v", s.source, err))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// do not retry on every request
+	s.loadedAt = time.Now()
+	s.err = err
+	return err
+}
+
+// Err returns the error of the last failed load or nil if the last load
+// succeeded.
+func (s *JWKS) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
+
+// jwk is a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. Keys of other types than oct, RSA and
+// EC on curve P-256, and keys not used for signatures, are skipped.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.E(fmt.Errorf("invalid JWKS: %v", err))
+	}
+
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, errors.E(fmt.Errorf("invalid JWKS key %d (kid '%s'): %v", i, k.Kid, err))
+		}
+		if key == nil {
+			continue
+		}
+		keys = append(keys, JWTKey{ID: k.Kid, Algorithm: k.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+// key returns the key or nil if its type is not supported.
+func (k jwk) key() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeJWKParam("k", k.K)
+
+	case "RSA":
+		n, err := decodeJWKParam("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKParam("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeJWKParam("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKParam("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point is not on curve P-256")
+		}
+		return pub, nil
+	}
+	return nil, nil
+}
+
+func decodeJWKParam(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("parameter %s is missing", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %s: %v", name, err)
+	}
+	return b, nil
+}
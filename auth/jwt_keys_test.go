@@ -0,0 +1,190 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   b64url(pub.N.Bytes()),
+		"e":   b64url(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   b64url(pub.X.FillBytes(make([]byte, 32))),
+		"y":   b64url(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := auth.ParseJWKS(jwksDocument(t,
+		rsaJWK("rs", &rsaKey.PublicKey),
+		ecJWK("es", &ecKey.PublicKey),
+		map[string]string{"kty": "oct", "kid": "hs", "k": b64url([]byte("some-secret"))},
+		// skipped
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AQAB"},
+		map[string]string{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AQAB", "y": "AQAB"},
+	))
+	require.NoError(t, err)
+	require.Equal(t, []auth.JWTKey{
+		{ID: "rs", Algorithm: "RS256", Key: &rsaKey.PublicKey},
+		{ID: "es", Key: &ecKey.PublicKey},
+		{ID: "hs", Key: []byte("some-secret")},
+	}, keys)
+
+	for _, doc := range []string{
+		`{"keys": [{"kty": "RSA", "n": "AQAB"}]}`,
+		`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "!"}]}`,
+		`{"keys": [{"kty": "oct"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`,
+		`{"keys": {}}`,
+	} {
+		_, err := auth.ParseJWKS([]byte(doc))
+		require.Error(t, err, doc)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, ioutil.WriteFile(path, jwksDocument(t, ecJWK("key-1", &ecKey.PublicKey)), 0600))
+
+	jwks, err := auth.NewJWKSFile(path, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, jwks.Keys("key-1"), 1)
+	require.Len(t, jwks.Keys(""), 1)
+
+	j := auth.NewJWT(auth.WithJWTKeys(jwks))
+	exp := time.Now().Add(time.Minute).Unix()
+	_, err = j.Verify(signJWT(t, "ES256", "key-1", ecKey, map[string]interface{}{"exp": exp}))
+	require.NoError(t, err)
+
+	// invalid file keeps the keys
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+	require.Error(t, jwks.Refresh())
+	require.Error(t, jwks.Err())
+	require.Len(t, jwks.Keys("key-1"), 1)
+
+	// rotated keys are loaded
+	require.NoError(t, ioutil.WriteFile(path, jwksDocument(t, ecJWK("key-2", &newKey.PublicKey)), 0600))
+	require.NoError(t, jwks.Refresh())
+	require.NoError(t, jwks.Err())
+	require.Empty(t, jwks.Keys("key-1"))
+	_, err = j.Verify(signJWT(t, "ES256", "key-2", newKey, map[string]interface{}{"exp": exp}))
+	require.NoError(t, err)
+
+	_, err = auth.NewJWKSFile(filepath.Join(t.TempDir(), "missing.json"), time.Hour)
+	require.Error(t, err)
+}
+
+func TestJWKSURL(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var requests int
+	doc := jwksDocument(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		_, _ = w.Write(doc)
+	}))
+	defer server.Close()
+
+	jwks, err := auth.NewJWKSURL(server.URL, 0, nil)
+	require.NoError(t, err)
+	require.Empty(t, jwks.Keys("rs"))
+
+	mu.Lock()
+	doc = jwksDocument(t, rsaJWK("rs", &rsaKey.PublicKey))
+	mu.Unlock()
+	require.Len(t, jwks.Keys("rs"), 1)
+
+	mu.Lock()
+	require.Equal(t, 3, requests)
+	mu.Unlock()
+
+	// concurrent requests load a due key set once
+	jwks, err = auth.NewJWKSURL(server.URL, 100*time.Millisecond, nil)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	requests = 0
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jwks.Keys("rs")
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	require.Equal(t, 1, requests)
+	mu.Unlock()
+
+	// not found
+	_, err = auth.NewJWKSURL(server.URL+"/missing", time.Hour, &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Body:       http.NoBody,
+			}, nil
+		}),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "404")
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JWTOption configures a JWT created by NewJWT or JWTMiddleware.
+type JWTOption func(*JWT)
+
+// WithJWTKeys sets the keys for verifying token signatures, e.g.
+// StaticJWTKeys or a JWKS.
+func WithJWTKeys(keys JWTKeySet) JWTOption {
+	return func(j *JWT) {
+		j.keys = keys
+	}
+}
+
+// WithJWTAlgorithms restricts the accepted signature algorithms.
+// Defaults to HS256, RS256 and ES256.
+func WithJWTAlgorithms(algs ...string) JWTOption {
+	return func(j *JWT) {
+		j.algorithms = algs
+	}
+}
+
+// WithJWTIssuer sets the required iss claim.
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(j *JWT) {
+		j.issuer = issuer
+	}
+}
+
+// WithJWTAudience sets the accepted audiences, the aud claim must contain at
+// least one of them.
+func WithJWTAudience(audience ...string) JWTOption {
+	return func(j *JWT) {
+		j.audience = audience
+	}
+}
+
+// WithJWTClockSkew sets the allowed clock difference between token issuer
+// and server for validating the exp and nbf claims. Defaults to 0.
+func WithJWTClockSkew(skew time.Duration) JWTOption {
+	return func(j *JWT) {
+		j.skew = skew
+	}
+}
+
+// WithJWTClock sets the function returning the current time, useful for
+// testing. Defaults to time.Now.
+func WithJWTClock(now func() time.Time) JWTOption {
+	return func(j *JWT) {
+		j.now = now
+	}
+}
+
+// WithJWTRequestLogger sets the function returning the logger of a request
+// used by the default error handler. Without it failures are not logged.
+func WithJWTRequestLogger(requestLogger func(r *http.Request) *zap.Logger) JWTOption {
+	return func(j *JWT) {
+		j.requestLogger = requestLogger
+	}
+}
+
+// WithJWTErrorHandler sets the function handling authorization failures.
+// Defaults to respond.JSONError with the logger of WithJWTRequestLogger.
+func WithJWTErrorHandler(
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error),
+) JWTOption {
+
+	return func(j *JWT) {
+		j.errorHandler = errorHandler
+	}
+}
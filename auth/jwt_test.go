@@ -0,0 +1,306 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/testhelpers"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// signJWT returns a token with the claims signed with the private key, a
+// []byte secret, *rsa.PrivateKey or *ecdsa.PrivateKey.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	logUnsugared, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	log, logs := testhelpers.ObserveLogs(t, logUnsugared.Sugar())
+
+	secret := []byte("some-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	handler := auth.JWTMiddleware(
+		auth.StaticJWTKeys{
+			{ID: "hs", Key: secret},
+			{ID: "rs", Key: &rsaKey.PublicKey},
+			{ID: "es", Key: &ecKey.PublicKey},
+		},
+		func(r *http.Request) *zap.Logger { return log.Desugar() },
+		auth.WithJWTIssuer("https://issuer.example.com"),
+		auth.WithJWTAudience("some-api", "other-api"),
+		auth.WithJWTClockSkew(30*time.Second),
+		auth.WithJWTClock(func() time.Time { return now }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := auth.JWTClaimsFromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(claims.Subject))
+	}))
+
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://issuer.example.com",
+			"sub": "some-user",
+			"aud": []string{"some-api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+		}
+	}
+
+	// all algorithms
+	for _, tt := range []struct {
+		alg, kid string
+		key      interface{}
+	}{
+		{alg: "HS256", kid: "hs", key: secret},
+		{alg: "RS256", kid: "rs", key: rsaKey},
+		{alg: "ES256", kid: "es", key: ecKey},
+		{alg: "ES256", key: ecKey},
+	} {
+		w := serve("Bearer " + signJWT(t, tt.alg, tt.kid, tt.key, validClaims()))
+		require.Equal(t, http.StatusOK, w.Code, tt.alg)
+		require.Equal(t, "some-user", w.Body.String())
+	}
+
+	// bearer scheme is case insensitive, aud may be a string
+	claims := validClaims()
+	claims["aud"] = "other-api"
+	w := serve("bearer " + signJWT(t, "HS256", "hs", secret, claims))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// within clock skew
+	claims = validClaims()
+	claims["exp"] = now.Add(-20 * time.Second).Unix()
+	claims["nbf"] = now.Add(20 * time.Second).Unix()
+	require.Equal(t, http.StatusOK, serve("Bearer "+signJWT(t, "HS256", "hs", secret, claims)).Code)
+
+	tests := []struct {
+		name          string
+		authorization func() string
+		err           string
+		noToken       bool
+	}{
+		{
+			name:          "missing header",
+			authorization: func() string { return "" },
+			err:           "not a bearer token",
+			noToken:       true,
+		},
+		{
+			name:          "basic auth",
+			authorization: func() string { return "Basic dXNlcjpwYXNz" },
+			err:           "not a bearer token",
+			noToken:       true,
+		},
+		{
+			name:          "malformed",
+			authorization: func() string { return "Bearer some.token" },
+			err:           "expected 3 parts",
+		},
+		{
+			name: "wrong secret",
+			authorization: func() string {
+				return "Bearer " + signJWT(t, "HS256", "hs", []byte("x"), validClaims())
+			},
+			err: "invalid token signature",
+		},
+		{
+			name: "wrong kid",
+			authorization: func() string {
+				return "Bearer " + signJWT(t, "ES256", "rs", ecKey, validClaims())
+			},
+			err: "invalid token signature",
+		},
+		{
+			name: "algorithm confusion",
+			authorization: func() string {
+				pub, err := json.Marshal(rsaKey.PublicKey)
+				require.NoError(t, err)
+				return "Bearer " + signJWT(t, "HS256", "rs", pub, validClaims())
+			},
+			err: "invalid token signature",
+		},
+		{
+			name: "none algorithm",
+			authorization: func() string {
+				token := signJWT(t, "none", "", []byte(nil), validClaims())
+				return "Bearer " + token[:strings.LastIndex(token, ".")+1]
+			},
+			err: "algorithm 'none' is not allowed",
+		},
+		{
+			name: "expired",
+			authorization: func() string {
+				c := validClaims()
+				c["exp"] = now.Add(-time.Minute).Unix()
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "expired",
+		},
+		{
+			name: "missing exp",
+			authorization: func() string {
+				c := validClaims()
+				delete(c, "exp")
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "claim exp is missing",
+		},
+		{
+			name: "not yet valid",
+			authorization: func() string {
+				c := validClaims()
+				c["nbf"] = now.Add(time.Minute).Unix()
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "not valid before",
+		},
+		{
+			name: "wrong issuer",
+			authorization: func() string {
+				c := validClaims()
+				c["iss"] = "https://other.example.com"
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "issuer",
+		},
+		{
+			name: "wrong audience",
+			authorization: func() string {
+				c := validClaims()
+				c["aud"] = []string{"unknown-api"}
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "audience",
+		},
+		{
+			name: "invalid claim type",
+			authorization: func() string {
+				c := validClaims()
+				c["exp"] = "tomorrow"
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "claim exp is not a number",
+		},
+		{
+			name: "claim out of range",
+			authorization: func() string {
+				c := validClaims()
+				c["exp"] = 1e300
+				return "Bearer " + signJWT(t, "HS256", "hs", secret, c)
+			},
+			err: "claim exp is not a valid number",
+		},
+	}
+	for _, tt := range tests {
+		w := serve(tt.authorization())
+		require.Equal(t, http.StatusUnauthorized, w.Code, tt.name)
+		challenge := `Bearer error="invalid_token"`
+		if tt.noToken {
+			challenge = "Bearer"
+		}
+		require.Equal(t, challenge, w.Header().Get("WWW-Authenticate"), tt.name)
+		require.Contains(t, w.Body.String(), "invalid authorization", tt.name)
+		testhelpers.RequireLastLogEntry(t, logs, zapcore.ErrorLevel, "",
+			map[string]string{"error": tt.err})
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	secret := []byte("some-secret")
+	exp := time.Now().Add(time.Minute).Unix()
+
+	j := auth.NewJWT(
+		auth.WithJWTKeys(auth.StaticJWTKeys{{Key: secret}}),
+		auth.WithJWTAlgorithms(auth.JWTAlgorithmHS256),
+	)
+	claims, err := j.Verify(signJWT(t, "HS256", "some-kid", secret, map[string]interface{}{
+		"sub":   "some-user",
+		"exp":   exp,
+		"iat":   1700000000.5,
+		"jti":   "some-id",
+		"roles": []string{"admin"},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "some-user", claims.Subject)
+	require.Equal(t, "some-id", claims.ID)
+	require.Equal(t, time.Unix(exp, 0), claims.ExpiresAt)
+	require.Equal(t, time.Unix(1700000000, 5e8), claims.IssuedAt)
+	require.Equal(t, []interface{}{"admin"}, claims.Claims["roles"])
+
+	// restricted algorithms
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	j = auth.NewJWT(
+		auth.WithJWTKeys(auth.StaticJWTKeys{{Key: &ecKey.PublicKey}}),
+		auth.WithJWTAlgorithms(auth.JWTAlgorithmHS256),
+	)
+	_, err = j.Verify(signJWT(t, "ES256", "", ecKey, map[string]interface{}{"exp": exp}))
+	require.Error(t, err)
+
+	// Authenticate returns errors.Unauthorized
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer x.y.z")
+	_, err = j.Authenticate(r)
+	require.True(t, errors.IsKind(errors.Unauthorized, err))
+}
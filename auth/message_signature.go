@@ -0,0 +1,538 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/errors"
+)
+
+// Request headers of HTTP message signatures (RFC 9421) and digests
+// (RFC 9530).
+const (
+	HeaderSignatureInput = "Signature-Input"
+	HeaderSignature      = "Signature"
+	HeaderContentDigest  = "Content-Digest"
+)
+
+// HTTP message signature algorithms.
+const (
+	MessageSignatureHMACSHA256 = "hmac-sha256"
+	MessageSignatureEd25519    = "ed25519"
+)
+
+// DefaultMessageSignatureLabel is the label of signatures made by SignMessage
+// if none is specified.
+const DefaultMessageSignatureLabel = "sig1"
+
+// DefaultMessageSignatureComponents are the components covered by SignMessage
+// if none are specified, and required by HMAC middlewares verifying message
+// signatures. content-digest is added for requests having a body.
+var DefaultMessageSignatureComponents = []string{
+	"@method", "@authority", "@path", "@query",
+}
+
+// MessageSignatureKey is a key for creating or verifying HTTP message
+// signatures.
+type MessageSignatureKey struct {
+	// ID is sent as keyid parameter. The HMAC middleware uses it as app ID.
+	ID string
+	// Algorithm is MessageSignatureHMACSHA256 or MessageSignatureEd25519.
+	// Defaults to MessageSignatureHMACSHA256 if Secret is set, otherwise to
+	// MessageSignatureEd25519.
+	Algorithm string
+	// Secret is the shared secret of hmac-sha256 signatures.
+	Secret []byte
+	// PrivateKey creates ed25519 signatures.
+	PrivateKey ed25519.PrivateKey
+	// PublicKey verifies ed25519 signatures. Defaults to the public key of
+	// PrivateKey.
+	PublicKey ed25519.PublicKey
+}
+
+// MessageSignatureParams are the parameters of a new HTTP message signature.
+type MessageSignatureParams struct {
+	// Label identifies the signature in the headers.
+	// Defaults to DefaultMessageSignatureLabel.
+	Label string
+	// Components are the covered component identifiers, e.g. "@method" or
+	// "content-type". Defaults to DefaultMessageSignatureComponents.
+	Components []string
+	// Created is the creation time. Defaults to the current time.
+	Created time.Time
+	// Expires is the optional expiration time.
+	Expires time.Time
+	// Nonce is a unique value. Defaults to a new UUID.
+	Nonce string
+	// Tag is the optional application specific tag.
+	Tag string
+}
+
+// MessageSignature is a verified HTTP message signature.
+type MessageSignature struct {
+	Label      string
+	KeyID      string
+	Algorithm  string
+	Components []string
+	Created    time.Time
+	Expires    time.Time
+	Nonce      string
+	Tag        string
+}
+
+// SignMessage signs the request according to RFC 9421 and adds the signature
+// to the Signature-Input and Signature headers. The Content-Digest header is
+// set if content-digest is covered and the header is missing.
+func SignMessage(r *http.Request, key MessageSignatureKey, params MessageSignatureParams) error {
+	if params.Label == "" {
+		params.Label = DefaultMessageSignatureLabel
+	}
+	components, defaults := params.Components, params.Components == nil
+	if defaults {
+		components = DefaultMessageSignatureComponents
+	}
+	params.Components = make([]string, len(components))
+	for i, c := range components {
+		params.Components[i] = strings.ToLower(c)
+	}
+	if defaults && r.Body != nil && r.Body != http.NoBody {
+		params.Components = append(params.Components, "content-digest")
+	}
+	if params.Created.IsZero() {
+		params.Created = time.Now()
+	}
+	if params.Nonce == "" {
+		params.Nonce = uuid.NewString()
+	}
+	alg := key.algorithm()
+
+	if containsString(params.Components, "content-digest") &&
+		r.Header.Get(HeaderContentDigest) == "" {
+		if err := SetContentDigest(r); err != nil {
+			return err
+		}
+	}
+
+	sigParams, err := serializeSignatureParams(params, alg, key.ID)
+	if err != nil {
+		return err
+	}
+	base, err := messageSignatureBase(r, params.Components, sigParams)
+	if err != nil {
+		return err
+	}
+	sig, err := key.sign(alg, []byte(base))
+	if err != nil {
+		return err
+	}
+
+	addDictionaryMember(r.Header, HeaderSignatureInput, params.Label+"="+sigParams)
+	addDictionaryMember(r.Header, HeaderSignature, params.Label+"="+sfByteSequence(sig))
+	return nil
+}
+
+// VerifyMessage verifies the HTTP message signature having the label, or the
+// first one if label is empty, with the key. The key ID and algorithm
+// parameters must match the key if present. If content-digest is covered, the
+// body must match the Content-Digest header. Creation and expiration times
+// and the nonce are not checked.
+func VerifyMessage(r *http.Request, label string, key MessageSignatureKey) (*MessageSignature, error) {
+	s, err := readMessageSignature(r, label)
+	if err != nil {
+		return nil, err
+	}
+	if key.ID != "" && s.KeyID != "" && s.KeyID != key.ID {
+		return nil, errors.E(fmt.Errorf("signature key ID '%s' does not match '%s'", s.KeyID, key.ID))
+	}
+	if err := s.verify(r, key); err != nil {
+		return nil, err
+	}
+	return &s.MessageSignature, nil
+}
+
+// MessageSignatureBase returns the signature base of the request, the data
+// actually signed, for the signature having the label or the first one if
+// label is empty. Useful for debugging clients.
+func MessageSignatureBase(r *http.Request, label string) (string, error) {
+	s, err := readMessageSignature(r, label)
+	if err != nil {
+		return "", err
+	}
+	return messageSignatureBase(r, s.Components, s.params)
+}
+
+// SetContentDigest sets the Content-Digest header of the request to the
+// SHA512 digest of the body.
+func SetContentDigest(r *http.Request) error {
+	body, err := rereadableBody(r)
+	if err != nil {
+		return err
+	}
+	digest := sha512.Sum512(body)
+	r.Header.Set(HeaderContentDigest, "sha-512="+sfByteSequence(digest[:]))
+	return nil
+}
+
+// VerifyContentDigest verifies the body of the request with all sha-256 and
+// sha-512 digests of the Content-Digest header. At least one of them must be
+// present. It reads the whole body, limit its size before, e.g. with
+// http.MaxBytesReader, HMAC.Authenticate limits it to the maximum body size.
+func VerifyContentDigest(r *http.Request) error {
+	header := strings.Join(r.Header.Values(HeaderContentDigest), ", ")
+	if header == "" {
+		return errors.E(fmt.Errorf("request header %s is missing", HeaderContentDigest))
+	}
+	digests, err := parseSFDictionary(header)
+	if err != nil {
+		return err
+	}
+	body, err := rereadableBody(r)
+	if err != nil {
+		return err
+	}
+
+	verified := false
+	for _, d := range digests {
+		var sum []byte
+		switch d.key {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		if !hmac.Equal(sum, d.bytes) {
+			return errors.E(fmt.Errorf("%s content digest mismatch", d.key))
+		}
+		verified = true
+	}
+	if !verified {
+		return errors.E(fmt.Errorf("request header %s has no supported digest", HeaderContentDigest))
+	}
+	return nil
+}
+
+// receivedMessageSignature is a message signature read from the request
+// headers, not yet verified.
+type receivedMessageSignature struct {
+	MessageSignature
+	// params is the serialized signature parameters as received.
+	params    string
+	signature []byte
+}
+
+// readMessageSignature reads the signature having the label, or the first one
+// if label is empty, from the request headers.
+func readMessageSignature(r *http.Request, label string) (*receivedMessageSignature, error) {
+	inputHeader := strings.Join(r.Header.Values(HeaderSignatureInput), ", ")
+	sigHeader := strings.Join(r.Header.Values(HeaderSignature), ", ")
+	if inputHeader == "" || sigHeader == "" {
+		return nil, errors.E(fmt.Errorf("request headers %s and %s must not be empty",
+			HeaderSignatureInput, HeaderSignature))
+	}
+
+	inputs, err := parseSFDictionary(inputHeader)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := parseSFDictionary(sigHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first signature if no label is given, the last member of
+	// duplicate keys counts (RFC 8941, section 4.2.2)
+	if label == "" && len(inputs) > 0 {
+		label = inputs[0].key
+	}
+	var input *sfMember
+	for i := range inputs {
+		if inputs[i].key == label {
+			input = &inputs[i]
+		}
+	}
+	if input == nil || input.bytes != nil {
+		return nil, errors.E(fmt.Errorf("request header %s has no signature '%s'",
+			HeaderSignatureInput, label))
+	}
+	var sig *sfMember
+	for i := range sigs {
+		if sigs[i].key == label {
+			sig = &sigs[i]
+		}
+	}
+	if sig == nil || sig.bytes == nil {
+		return nil, errors.E(fmt.Errorf("request header %s has no signature '%s'",
+			HeaderSignature, label))
+	}
+
+	s := &receivedMessageSignature{
+		MessageSignature: MessageSignature{Label: label},
+		params:           input.raw,
+		signature:        sig.bytes,
+	}
+	for _, item := range input.list {
+		if len(item.params) > 0 {
+			return nil, errors.E(fmt.Errorf("component '%s' has unsupported parameters", item.value))
+		}
+		s.Components = append(s.Components, item.value)
+	}
+
+	for _, p := range input.params {
+		var ok bool
+		switch p.key {
+		case "created", "expires":
+			var ts int64
+			if ts, ok = p.value.(int64); ok {
+				if p.key == "created" {
+					s.Created = time.Unix(ts, 0)
+				} else {
+					s.Expires = time.Unix(ts, 0)
+				}
+			}
+		case "keyid":
+			s.KeyID, ok = p.value.(string)
+		case "alg":
+			s.Algorithm, ok = p.value.(string)
+		case "nonce":
+			s.Nonce, ok = p.value.(string)
+		case "tag":
+			s.Tag, ok = p.value.(string)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, errors.E(fmt.Errorf("signature parameter %s has an invalid value", p.key))
+		}
+	}
+	return s, nil
+}
+
+// verify verifies the signature and, if covered, the content digest.
+func (s *receivedMessageSignature) verify(r *http.Request, key MessageSignatureKey) error {
+	alg := key.algorithm()
+	if s.Algorithm != "" && s.Algorithm != alg {
+		return errors.E(fmt.Errorf("signature algorithm '%s' does not match key algorithm '%s'",
+			s.Algorithm, alg))
+	}
+	base, err := messageSignatureBase(r, s.Components, s.params)
+	if err != nil {
+		return err
+	}
+	if err := key.verify(alg, []byte(base), s.signature); err != nil {
+		return err
+	}
+	s.Algorithm = alg
+
+	if containsString(s.Components, "content-digest") {
+		return VerifyContentDigest(r)
+	}
+	return nil
+}
+
+func (k MessageSignatureKey) algorithm() string {
+	switch {
+	case k.Algorithm != "":
+		return k.Algorithm
+	case k.Secret != nil:
+		return MessageSignatureHMACSHA256
+	}
+	return MessageSignatureEd25519
+}
+
+func (k MessageSignatureKey) sign(alg string, base []byte) ([]byte, error) {
+	switch alg {
+	case MessageSignatureHMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(base)
+		return mac.Sum(nil), nil
+	case MessageSignatureEd25519:
+		if len(k.PrivateKey) != ed25519.PrivateKeySize {
+			return nil, errors.E(fmt.Errorf("invalid ed25519 private key"))
+		}
+		return ed25519.Sign(k.PrivateKey, base), nil
+	}
+	return nil, errors.E(fmt.Errorf("unsupported message signature algorithm '%s'", alg))
+}
+
+func (k MessageSignatureKey) verify(alg string, base, signature []byte) error {
+	switch alg {
+	case MessageSignatureHMACSHA256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(base)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.E(fmt.Errorf("signature mismatch"))
+		}
+		return nil
+	case MessageSignatureEd25519:
+		pub := k.PublicKey
+		if pub == nil && len(k.PrivateKey) == ed25519.PrivateKeySize {
+			pub = k.PrivateKey.Public().(ed25519.PublicKey)
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return errors.E(fmt.Errorf("invalid ed25519 public key"))
+		}
+		if !ed25519.Verify(pub, base, signature) {
+			return errors.E(fmt.Errorf("signature mismatch"))
+		}
+		return nil
+	}
+	return errors.E(fmt.Errorf("unsupported message signature algorithm '%s'", alg))
+}
+
+// serializeSignatureParams returns the value of the signature in the
+// Signature-Input header, the covered components and the parameters.
+func serializeSignatureParams(params MessageSignatureParams, alg, keyID string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, c := range params.Components {
+		s, err := sfString(c)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte(')')
+
+	b.WriteString(";created=" + strconv.FormatInt(params.Created.Unix(), 10))
+	if !params.Expires.IsZero() {
+		b.WriteString(";expires=" + strconv.FormatInt(params.Expires.Unix(), 10))
+	}
+	for _, p := range []struct{ key, value string }{
+		{"nonce", params.Nonce},
+		{"alg", alg},
+		{"keyid", keyID},
+		{"tag", params.Tag},
+	} {
+		if p.value == "" {
+			continue
+		}
+		s, err := sfString(p.value)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(";" + p.key + "=" + s)
+	}
+	return b.String(), nil
+}
+
+// messageSignatureBase returns the signature base of the request for the
+// covered components and the serialized signature parameters.
+func messageSignatureBase(r *http.Request, components []string, params string) (string, error) {
+	var b strings.Builder
+	seen := make(map[string]bool, len(components))
+	for _, c := range components {
+		if seen[c] {
+			return "", errors.E(fmt.Errorf("component '%s' is covered more than once", c))
+		}
+		seen[c] = true
+
+		value, err := messageComponentValue(r, c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(`"` + c + `": ` + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + params)
+	return b.String(), nil
+}
+
+// messageComponentValue returns the value of a derived component or header
+// field of the request.
+func messageComponentValue(r *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return messageScheme(r) + "://" + messageAuthority(r) + r.URL.RequestURI(), nil
+	case "@authority":
+		return messageAuthority(r), nil
+	case "@scheme":
+		return messageScheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", errors.E(fmt.Errorf("unsupported derived component '%s'", component))
+	}
+
+	if component == "host" {
+		return messageAuthority(r), nil
+	}
+	values := r.Header.Values(component)
+	if len(values) == 0 {
+		return "", errors.E(fmt.Errorf("covered header '%s' is missing", component))
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+func messageScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// messageAuthority returns the lower cased host of the request without
+// default port.
+func messageAuthority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	host = strings.ToLower(host)
+
+	switch scheme := messageScheme(r); {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		return strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		return strings.TrimSuffix(host, ":443")
+	}
+	return host
+}
+
+// addDictionaryMember adds a member to the structured field dictionary
+// header, keeping existing members.
+func addDictionaryMember(h http.Header, name, member string) {
+	if existing := h.Get(name); existing != "" {
+		member = existing + ", " + member
+	}
+	h.Set(name, member)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,439 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// rfcTestRequest returns the test request of RFC 9421 appendix B.2.
+func rfcTestRequest() *http.Request {
+	r := httptest.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog",
+		strings.NewReader(`{"hello": "world"}`))
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+"+
+		"TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	r.Header.Set("Content-Length", "18")
+	return r
+}
+
+func TestVerifyMessageRFC9421(t *testing.T) {
+	// B.2.5. Signing a Request Using hmac-sha256
+	secret, err := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgc" +
+		"u46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	require.NoError(t, err)
+
+	r := rfcTestRequest()
+	r.Header.Set("Signature-Input", `sig-b25=("date" "@authority" "content-type")`+
+		`;created=1618884473;keyid="test-shared-secret"`)
+	r.Header.Set("Signature", "sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:")
+
+	base, err := auth.MessageSignatureBase(r, "sig-b25")
+	require.NoError(t, err)
+	require.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+		base)
+
+	sig, err := auth.VerifyMessage(r, "sig-b25", auth.MessageSignatureKey{
+		ID:     "test-shared-secret",
+		Secret: secret,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &auth.MessageSignature{
+		Label:      "sig-b25",
+		KeyID:      "test-shared-secret",
+		Algorithm:  auth.MessageSignatureHMACSHA256,
+		Components: []string{"date", "@authority", "content-type"},
+		Created:    time.Unix(1618884473, 0),
+	}, sig)
+
+	_, err = auth.VerifyMessage(r, "", auth.MessageSignatureKey{Secret: []byte("x")})
+	require.Error(t, err)
+	_, err = auth.VerifyMessage(r, "", auth.MessageSignatureKey{ID: "other", Secret: secret})
+	require.Error(t, err)
+	_, err = auth.VerifyMessage(r, "other", auth.MessageSignatureKey{Secret: secret})
+	require.Error(t, err)
+
+	// B.2.6. Signing a Request Using ed25519
+	block, _ := pem.Decode([]byte(`-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=
+-----END PUBLIC KEY-----`))
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+
+	r = rfcTestRequest()
+	r.Header.Set("Signature-Input", `sig-b26=("date" "@method" "@path" "@authority" `+
+		`"content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+	r.Header.Set("Signature", "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02"+
+		"Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:")
+
+	sig, err = auth.VerifyMessage(r, "", auth.MessageSignatureKey{
+		PublicKey: pub.(ed25519.PublicKey),
+	})
+	require.NoError(t, err)
+	require.Equal(t, auth.MessageSignatureEd25519, sig.Algorithm)
+	require.Equal(t, "sig-b26", sig.Label)
+}
+
+func TestSignMessage(t *testing.T) {
+	secret := []byte("some-secret")
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	keys := []auth.MessageSignatureKey{
+		{ID: "some-hmac-key", Secret: secret},
+		{ID: "some-ed25519-key", PrivateKey: priv},
+	}
+	for _, key := range keys {
+		r := httptest.NewRequest("POST", "https://example.com:443/orders?b=2&a=1",
+			strings.NewReader(`{"id": 1}`))
+		r.Header.Set("Content-Type", "application/json")
+
+		created := time.Unix(1618884473, 0)
+		err := auth.SignMessage(r, key, auth.MessageSignatureParams{
+			Created: created,
+			Nonce:   "some-nonce",
+		})
+		require.NoError(t, err)
+
+		alg := auth.MessageSignatureHMACSHA256
+		if key.Secret == nil {
+			alg = auth.MessageSignatureEd25519
+		}
+		require.Equal(t, `sig1=("@method" "@authority" "@path" "@query" "content-digest")`+
+			`;created=1618884473;nonce="some-nonce";alg="`+alg+`";keyid="`+key.ID+`"`,
+			r.Header.Get(auth.HeaderSignatureInput))
+		require.Equal(t, "sha-512=:qh4s/M92pX6ajCAiBudMP7FQrLvOjFjrKcTe10r2lW/cf6os5pIN"+
+			"u4/OfCOszoq7dRAZus73qUtZIVx3vIjLxQ==:", r.Header.Get(auth.HeaderContentDigest))
+
+		base, err := auth.MessageSignatureBase(r, "")
+		require.NoError(t, err)
+		require.Equal(t, `"@method": POST
+"@authority": example.com
+"@path": /orders
+"@query": ?b=2&a=1
+"content-digest": `+r.Header.Get(auth.HeaderContentDigest)+`
+"@signature-params": `+r.Header.Get(auth.HeaderSignatureInput)[len("sig1="):], base)
+
+		verifyKey := key
+		if key.PrivateKey != nil {
+			verifyKey = auth.MessageSignatureKey{PublicKey: pub}
+		}
+		sig, err := auth.VerifyMessage(r, "", verifyKey)
+		require.NoError(t, err)
+		require.Equal(t, &auth.MessageSignature{
+			Label:      "sig1",
+			KeyID:      key.ID,
+			Algorithm:  alg,
+			Components: []string{"@method", "@authority", "@path", "@query", "content-digest"},
+			Created:    created,
+			Nonce:      "some-nonce",
+		}, sig)
+
+		// body is still readable
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{"id": 1}`, string(body))
+
+		// modified body
+		r.Body = ioutil.NopCloser(strings.NewReader(`{"id": 2}`))
+		_, err = auth.VerifyMessage(r, "", verifyKey)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "content digest mismatch")
+	}
+
+	// a second signature is added with another label
+	r := httptest.NewRequest("GET", "/", nil)
+	require.NoError(t, auth.SignMessage(r, keys[0], auth.MessageSignatureParams{}))
+	require.NoError(t, auth.SignMessage(r, keys[1], auth.MessageSignatureParams{
+		Label:      "sig2",
+		Components: []string{"@method", "@target-uri"},
+		Expires:    time.Now().Add(time.Minute),
+		Tag:        "some-tag",
+	}))
+	sig, err := auth.VerifyMessage(r, "sig2", auth.MessageSignatureKey{PublicKey: pub})
+	require.NoError(t, err)
+	require.Equal(t, "some-tag", sig.Tag)
+	require.False(t, sig.Expires.IsZero())
+	_, err = auth.VerifyMessage(r, "", keys[0])
+	require.NoError(t, err)
+
+	// the last member of duplicate labels counts
+	r = httptest.NewRequest("GET", "/", nil)
+	require.NoError(t, auth.SignMessage(r, keys[0], auth.MessageSignatureParams{}))
+	input := r.Header.Get(auth.HeaderSignatureInput)
+	signature := r.Header.Get(auth.HeaderSignature)
+	r.Header.Set(auth.HeaderSignatureInput, `sig1=("@path"), `+input)
+	r.Header.Set(auth.HeaderSignature, "sig1=:AAAA:, "+signature)
+	_, err = auth.VerifyMessage(r, "", keys[0])
+	require.NoError(t, err)
+	r.Header.Set(auth.HeaderSignatureInput, input+`, sig1=("@path")`)
+	r.Header.Set(auth.HeaderSignature, signature)
+	_, err = auth.VerifyMessage(r, "", keys[0])
+	require.Error(t, err)
+	r.Header.Set(auth.HeaderSignatureInput, input)
+	r.Header.Set(auth.HeaderSignature, signature+", sig1=:AAAA:")
+	_, err = auth.VerifyMessage(r, "", keys[0])
+	require.Error(t, err)
+
+	// missing covered header
+	r = httptest.NewRequest("GET", "/", nil)
+	err = auth.SignMessage(r, keys[0], auth.MessageSignatureParams{
+		Components: []string{"@method", "x-missing"},
+	})
+	require.Error(t, err)
+}
+
+func TestVerifyMessageInvalidHeaders(t *testing.T) {
+	key := auth.MessageSignatureKey{Secret: []byte("some-secret")}
+	tests := []struct {
+		input, signature string
+	}{
+		{input: "", signature: ""},
+		{input: `sig1=("@method")`, signature: ""},
+		{input: `sig1=("@method"`, signature: "sig1=:AAAA:"},
+		{input: `sig1=(@method)`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@method");created=x`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@method");created="1"`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@method"),`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@method")`, signature: "sig2=:AAAA:"},
+		{input: `sig1=("@method")`, signature: "sig1=:A!AA:"},
+		{input: `sig1=("@method" "@method")`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@unknown")`, signature: "sig1=:AAAA:"},
+		{input: `sig1=("@query-param";name="a")`, signature: "sig1=:AAAA:"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(auth.HeaderSignatureInput, tt.input)
+		r.Header.Set(auth.HeaderSignature, tt.signature)
+		_, err := auth.VerifyMessage(r, "", key)
+		require.Error(t, err, tt.input)
+	}
+}
+
+func TestContentDigest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"hello": "world"}`))
+	r.Header.Set(auth.HeaderContentDigest, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:, "+
+		"unknown=:AAAA:")
+	require.NoError(t, auth.VerifyContentDigest(r))
+
+	r.Header.Set(auth.HeaderContentDigest, "sha-256=:AAAA:")
+	require.Error(t, auth.VerifyContentDigest(r))
+	r.Header.Set(auth.HeaderContentDigest, "unknown=:AAAA:")
+	require.Error(t, auth.VerifyContentDigest(r))
+	r.Header.Del(auth.HeaderContentDigest)
+	require.Error(t, auth.VerifyContentDigest(r))
+
+	require.NoError(t, auth.SetContentDigest(r))
+	require.NoError(t, auth.VerifyContentDigest(r))
+}
+
+func TestHMACMiddlewareMessageSignatures(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	edAppID := "some-ed25519-app-id"
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	nonceExpiration := 2 * time.Second
+
+	var lastAppID string
+	var lastErr error
+	handler := auth.HMACMiddleware(
+		map[string][]byte{appID: secret},
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACMessageSignatures(map[string]ed25519.PublicKey{edAppID: pub}),
+		auth.WithHMACScopes(map[string][]string{edAppID: {"orders:write"}}),
+		auth.WithHMACObserver(func(r *http.Request, appID string, err error) {
+			lastAppID, lastErr = appID, err
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := auth.HMACPrincipalFromContext(r.Context())
+		require.True(t, ok)
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		fmt.Fprintf(w, "%s %v %s", p.AppID, p.Scopes, body)
+	}))
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	newRequest := func(body string) *http.Request {
+		if body == "" {
+			return httptest.NewRequest("GET", "/orders?id=1", nil)
+		}
+		return httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	}
+	hmacKey := auth.MessageSignatureKey{ID: appID, Secret: secret}
+	edKey := auth.MessageSignatureKey{ID: edAppID, PrivateKey: priv}
+
+	// hmac-sha256
+	r := newRequest("")
+	require.NoError(t, auth.SignMessage(r, hmacKey, auth.MessageSignatureParams{}))
+	w := serve(r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "some-app-id [] ", w.Body.String())
+	require.Equal(t, appID, lastAppID)
+
+	// replayed
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+	require.True(t, errors.Is(lastErr, auth.ErrHMACReplayedNonce))
+
+	// ed25519 with body
+	r = newRequest(`{"id": 1}`)
+	require.NoError(t, auth.SignMessage(r, edKey, auth.MessageSignatureParams{}))
+	w = serve(r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, `some-ed25519-app-id [orders:write] {"id": 1}`, w.Body.String())
+
+	// X-Auth-* headers still work
+	r = newRequest("")
+	require.NoError(t, auth.HMACSignRequest(r, appID, secret, "some-nonce",
+		fmt.Sprintf("%d", time.Now().Unix()), auth.HMACLegacyPayload))
+	require.Equal(t, http.StatusOK, serve(r).Code)
+
+	tests := []struct {
+		name   string
+		body   string
+		key    auth.MessageSignatureKey
+		params auth.MessageSignatureParams
+		modify func(r *http.Request)
+		reason auth.HMACFailure
+	}{
+		{
+			name:   "wrong secret",
+			key:    auth.MessageSignatureKey{ID: appID, Secret: []byte("x")},
+			reason: auth.ErrHMACInvalidSignature,
+		},
+		{
+			name:   "unknown app ID",
+			key:    auth.MessageSignatureKey{ID: "unknown", Secret: secret},
+			reason: auth.ErrHMACUnknownAppID,
+		},
+		{
+			name:   "missing key ID",
+			key:    auth.MessageSignatureKey{Secret: secret},
+			reason: auth.ErrHMACMissingHeader,
+		},
+		{
+			name:   "missing signature",
+			key:    hmacKey,
+			modify: func(r *http.Request) { r.Header.Del(auth.HeaderSignature) },
+			reason: auth.ErrHMACMissingHeader,
+		},
+		{
+			name:   "wrong algorithm",
+			key:    auth.MessageSignatureKey{ID: edAppID, Secret: secret},
+			reason: auth.ErrHMACInvalidSignature,
+		},
+		{
+			name: "unsupported algorithm",
+			key:  hmacKey,
+			modify: func(r *http.Request) {
+				r.Header.Set(auth.HeaderSignatureInput, strings.Replace(
+					r.Header.Get(auth.HeaderSignatureInput), "hmac-sha256", "rsa-pss-sha512", 1))
+			},
+			reason: auth.ErrHMACUnsupportedAlgorithm,
+		},
+		{
+			name:   "expired",
+			key:    hmacKey,
+			params: auth.MessageSignatureParams{Created: time.Now().Add(-time.Minute)},
+			reason: auth.ErrHMACExpired,
+		},
+		{
+			name:   "expires",
+			key:    hmacKey,
+			params: auth.MessageSignatureParams{Expires: time.Now().Add(-time.Second)},
+			reason: auth.ErrHMACExpired,
+		},
+		{
+			name:   "future",
+			key:    hmacKey,
+			params: auth.MessageSignatureParams{Created: time.Now().Add(time.Minute)},
+			reason: auth.ErrHMACInvalidTimestamp,
+		},
+		{
+			name:   "path not covered",
+			key:    hmacKey,
+			params: auth.MessageSignatureParams{Components: []string{"@method", "@authority"}},
+			reason: auth.ErrHMACInvalidSignature,
+		},
+		{
+			name: "content digest not covered",
+			body: `{"id": 1}`,
+			key:  hmacKey,
+			params: auth.MessageSignatureParams{
+				Components: auth.DefaultMessageSignatureComponents,
+			},
+			reason: auth.ErrHMACInvalidSignature,
+		},
+		{
+			name: "modified body",
+			body: `{"id": 1}`,
+			key:  hmacKey,
+			modify: func(r *http.Request) {
+				r.Body = ioutil.NopCloser(strings.NewReader(`{"id": 2}`))
+			},
+			reason: auth.ErrHMACInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		r := newRequest(tt.body)
+		require.NoError(t, auth.SignMessage(r, tt.key, tt.params), tt.name)
+		if tt.modify != nil {
+			tt.modify(r)
+		}
+		require.Equal(t, http.StatusUnauthorized, serve(r).Code, tt.name)
+		require.True(t, errors.Is(lastErr, tt.reason), "%s: %v", tt.name, lastErr)
+	}
+}
+
+func TestHMACMessageSignatureMaxBodySize(t *testing.T) {
+	appID := "some-app-id"
+	secret := []byte("some-secret")
+	h := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{appID: secret}),
+		auth.WithHMACMessageSignatures(nil),
+		auth.WithHMACMaxBodySize(16),
+	)
+	key := auth.MessageSignatureKey{ID: appID, Secret: secret}
+	newRequest := func(body string) *http.Request {
+		r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		require.NoError(t, auth.SignMessage(r, key, auth.MessageSignatureParams{}))
+		return r
+	}
+
+	_, err := h.Authenticate(newRequest(`{"id": 1}`))
+	require.NoError(t, err)
+
+	// the content digest is not verified for large bodies
+	r := newRequest(strings.Repeat("x", 17))
+	_, err = h.Authenticate(r)
+	require.True(t, errors.Is(err, auth.ErrHMACBodyTooLarge), err)
+
+	r = newRequest(strings.Repeat("x", 17))
+	r.ContentLength = -1
+	_, err = h.Authenticate(r)
+	require.True(t, errors.Is(err, auth.ErrHMACBodyTooLarge), err)
+}
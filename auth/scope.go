@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/respond"
+	"go.uber.org/zap"
+)
+
+// RequireScope returns a middleware which only passes requests of principals
+// having the specified scope. Other requests are rejected with
+// errors.Unauthorized if not authenticated or with errors.Forbidden if the
+// scope is missing.
+// It must be used after RequireAuth, HMACMiddleware, JWTMiddleware or
+// APIKeyMiddleware. Scopes are granted with the WithHMACScopes option, the
+// scope or scp claim of JWTs and APIKey.Scopes. If several principals are
+// authenticated, e.g. by AllOf or stacked middlewares, every one of them
+// must have the scope. requestLogger can be nil.
+func RequireScope(
+	scope string,
+	requestLogger func(r *http.Request) *zap.Logger,
+) func(next http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var log *zap.Logger
+			if requestLogger != nil {
+				log = requestLogger(r)
+			}
+
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				err := fmt.Errorf(
+					"invalid authorization: request is not authenticated")
+				err = errors.E(err, errors.Unauthorized, "invalid authorization")
+				respond.JSONError(w, log, err)
+				return
+			}
+
+			if !p.HasScope(scope) {
+				err := fmt.Errorf(
+					"insufficient scope: principal '%s' lacks scope '%s'",
+					p.PrincipalID(), scope)
+				err = errors.E(err, errors.Forbidden, "insufficient scope")
+				respond.JSONError(w, log, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRequireScope(t *testing.T) {
+	secrets := map[string][]byte{
+		"reader-app": []byte("reader-secret"),
+		"writer-app": []byte("writer-secret"),
+		"other-app":  []byte("other-secret"),
+	}
+	scopes := map[string][]string{
+		"reader-app": {"orders:read"},
+		"writer-app": {"orders:read", "orders:write"},
+	}
+
+	nonceExpiration := 2 * time.Second
+	hmacMiddleware := auth.HMACMiddleware(
+		secrets,
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		func(r *http.Request) *zap.Logger { return zap.NewNop() },
+		auth.WithHMACScopes(scopes),
+	)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/read", hmacMiddleware(auth.RequireScope("orders:read", nil)(ok)))
+	mux.Handle("/write", hmacMiddleware(auth.RequireScope("orders:write", nil)(ok)))
+	mux.Handle("/unauthenticated", auth.RequireScope("orders:read", nil)(ok))
+
+	requireStatus := func(status int, path, appID, expectedBody string) {
+		nonce := uuid.NewString()
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		r := httptest.NewRequest("GET", path, nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp,
+			auth.HMACSign(secrets[appID], []byte(nonce+timestamp)))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		require.Equal(t, status, w.Code, w.Body.String())
+		if expectedBody != "" {
+			require.Equal(t, expectedBody, w.Body.String())
+		}
+	}
+
+	requireStatus(http.StatusOK, "/read", "reader-app", "")
+	requireStatus(http.StatusForbidden, "/write", "reader-app",
+		`{"msg":"insufficient scope"}`)
+	requireStatus(http.StatusOK, "/read", "writer-app", "")
+	requireStatus(http.StatusOK, "/write", "writer-app", "")
+
+	// app without scopes
+	requireStatus(http.StatusForbidden, "/read", "other-app", "")
+
+	// not authenticated by HMACMiddleware
+	requireStatus(http.StatusUnauthorized, "/unauthenticated", "reader-app",
+		`{"msg":"invalid authorization"}`)
+}
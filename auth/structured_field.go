@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iconimpact/go-core/errors"
+)
+
+// sfMember is a member of a structured field dictionary (RFC 8941) as used by
+// the Signature-Input, Signature and Content-Digest headers. The value is
+// either an inner list of strings or a byte sequence.
+type sfMember struct {
+	key string
+	// raw is the serialized value including parameters, as received.
+	raw    string
+	list   []sfItem
+	bytes  []byte
+	params sfParams
+}
+
+// sfItem is a string of an inner list with its parameters.
+type sfItem struct {
+	value  string
+	params sfParams
+}
+
+// sfParams are the parameters of a list, item or dictionary member. The
+// values are int64, string, []byte or bool.
+type sfParams []sfParam
+
+type sfParam struct {
+	key   string
+	value interface{}
+}
+
+// parseSFDictionary parses a structured field dictionary having inner lists
+// of strings or byte sequences as values.
+func parseSFDictionary(s string) ([]sfMember, error) {
+	p := &sfParser{s: s}
+	var members []sfMember
+	p.skipOWS()
+	for !p.done() {
+		m, err := p.member()
+		if err != nil {
+			return nil, errors.E(fmt.Errorf("invalid structured field at offset %d: %w", p.i, err))
+		}
+		members = append(members, m)
+
+		p.skipOWS()
+		if p.done() {
+			break
+		}
+		if p.s[p.i] != ',' {
+			return nil, errors.E(fmt.Errorf("invalid structured field at offset %d: expected ','", p.i))
+		}
+		p.i++
+		p.skipOWS()
+		if p.done() {
+			return nil, errors.E(fmt.Errorf("invalid structured field: trailing ','"))
+		}
+	}
+	return members, nil
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) done() bool { return p.i >= len(p.s) }
+
+func (p *sfParser) skipOWS() {
+	for !p.done() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipSP() {
+	for !p.done() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) member() (sfMember, error) {
+	key, err := p.key()
+	if err != nil {
+		return sfMember{}, err
+	}
+	m := sfMember{key: key}
+	if p.done() || p.s[p.i] != '=' {
+		return m, fmt.Errorf("member '%s' has no value", key)
+	}
+	p.i++
+
+	start := p.i
+	switch {
+	case p.done():
+		return m, fmt.Errorf("member '%s' has no value", key)
+	case p.s[p.i] == '(':
+		if m.list, err = p.innerList(); err != nil {
+			return m, err
+		}
+	case p.s[p.i] == ':':
+		if m.bytes, err = p.byteSequence(); err != nil {
+			return m, err
+		}
+	default:
+		return m, fmt.Errorf("member '%s' is not an inner list or byte sequence", key)
+	}
+	if m.params, err = p.params(); err != nil {
+		return m, err
+	}
+	m.raw = p.s[start:p.i]
+	return m, nil
+}
+
+func (p *sfParser) innerList() ([]sfItem, error) {
+	p.i++ // (
+	var items []sfItem
+	for {
+		p.skipSP()
+		if p.done() {
+			return nil, fmt.Errorf("unterminated inner list")
+		}
+		if p.s[p.i] == ')' {
+			p.i++
+			return items, nil
+		}
+		if len(items) > 0 && p.s[p.i-1] != ' ' {
+			return nil, fmt.Errorf("expected ' ' between inner list items")
+		}
+		if p.s[p.i] != '"' {
+			return nil, fmt.Errorf("inner list item is not a string")
+		}
+		value, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		params, err := p.params()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, sfItem{value: value, params: params})
+	}
+}
+
+func (p *sfParser) params() (sfParams, error) {
+	var params sfParams
+	for !p.done() && p.s[p.i] == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if !p.done() && p.s[p.i] == '=' {
+			p.i++
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{key: key, value: value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.i
+	if p.done() || !(isLCAlpha(p.s[p.i]) || p.s[p.i] == '*') {
+		return "", fmt.Errorf("invalid key")
+	}
+	for !p.done() {
+		c := p.s[p.i]
+		if !(isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*') {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) bareItem() (interface{}, error) {
+	if p.done() {
+		return nil, fmt.Errorf("missing parameter value")
+	}
+	switch c := p.s[p.i]; {
+	case c == '"':
+		return p.str()
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		if p.i+1 < len(p.s) && (p.s[p.i+1] == '0' || p.s[p.i+1] == '1') {
+			p.i += 2
+			return p.s[p.i-1] == '1', nil
+		}
+		return nil, fmt.Errorf("invalid boolean")
+	case c == '-' || isDigit(c):
+		start := p.i
+		p.i++
+		for !p.done() && isDigit(p.s[p.i]) {
+			p.i++
+		}
+		return strconv.ParseInt(p.s[start:p.i], 10, 64)
+	case isAlpha(c) || c == '*':
+		start := p.i
+		for !p.done() && isTokenChar(p.s[p.i]) {
+			p.i++
+		}
+		return p.s[start:p.i], nil
+	}
+	return nil, fmt.Errorf("unsupported parameter value")
+}
+
+func (p *sfParser) str() (string, error) {
+	p.i++ // "
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.done() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", fmt.Errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", fmt.Errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.i++ // :
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated byte sequence")
+	}
+	b, err := base64.StdEncoding.DecodeString(p.s[p.i : p.i+end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte sequence: %v", err)
+	}
+	p.i += end + 1
+	return b, nil
+}
+
+// sfString serializes s as structured field string.
+func sfString(s string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e {
+			return "", errors.E(fmt.Errorf("invalid character in structured field string %q", s))
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
+
+// sfByteSequence serializes b as structured field byte sequence.
+func sfByteSequence(b []byte) string {
+	return ":" + base64.StdEncoding.EncodeToString(b) + ":"
+}
+
+func isLCAlpha(c byte) bool { return c >= 'a' && c <= 'z' }
+func isAlpha(c byte) bool   { return isLCAlpha(c) || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool   { return c >= '0' && c <= '9' }
+
+func isTokenChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
@@ -0,0 +1,375 @@
+/*
+  Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Command hmacsign signs HTTP requests for the HMAC middleware of package
+// auth and explains why a set of signature headers is rejected by it.
+//
+//	hmacsign sign -app-id Dispoman -secret s3cr3t
+//	hmacsign sign -app-id Dispoman -secret s3cr3t -curl -method POST \
+//	    -url https://api.example.com/orders -data '{"id":1}' -canonical
+//	hmacsign verify -secret s3cr3t -H 'X-Auth-App-ID: Dispoman' ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+)
+
+const usage = `Usage:
+  hmacsign sign   -app-id ID -secret SECRET [flags]   print the X-Auth-* headers
+  hmacsign verify -secret SECRET -H 'Name: value'...  explain the HMACMiddleware result
+
+Run 'hmacsign <command> -h' for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code, 0 on
+// success, 1 if verify fails and 2 on usage errors.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "sign":
+		err = sign(args[1:], stdout, stderr)
+	case "verify":
+		err = verify(args[1:], stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command '%s'\n\n%s", args[0], usage)
+		return 2
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case err == flag.ErrHelp:
+		return 0
+	case errors.Is(err, errVerify):
+		return 1
+	default:
+		fmt.Fprintf(stderr, "hmacsign %s: %v\n", args[0], err)
+		return 2
+	}
+}
+
+// errVerify is returned by verify if the middleware would reject the
+// request. The explanation is already printed.
+var errVerify = errors.E(fmt.Errorf("verification failed"))
+
+// requestFlags are the flags describing the signed request, shared by all
+// commands.
+type requestFlags struct {
+	method        string
+	url           string
+	data          string
+	headers       headerFlags
+	canonical     bool
+	signedHeaders string
+}
+
+func (f *requestFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.method, "method", "GET", "HTTP `method` of the request")
+	fs.StringVar(&f.url, "url", "http://localhost/", "`URL` of the request")
+	fs.StringVar(&f.data, "data", "", "`body` of the request")
+	fs.Var(&f.headers, "H", "request `header` 'Name: value', can be repeated")
+	fs.BoolVar(&f.canonical, "canonical", false,
+		"sign the canonical request (HMACCanonicalPayload) instead of nonce and timestamp")
+	fs.StringVar(&f.signedHeaders, "signed-headers", "",
+		"comma-separated `names` of the headers signed in canonical mode")
+}
+
+// request returns the described request.
+func (f *requestFlags) request() (*http.Request, error) {
+	r, err := http.NewRequest(f.method, f.url, strings.NewReader(f.data))
+	if err != nil {
+		return nil, err
+	}
+	if f.data == "" {
+		r.Body = http.NoBody
+	}
+	for _, h := range f.headers {
+		name, value, err := splitHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "Host") {
+			r.Host = value
+			continue
+		}
+		r.Header.Add(name, value)
+	}
+	return r, nil
+}
+
+// payload returns the payload function of the server.
+func (f *requestFlags) payload() auth.HMACPayloadFunc {
+	if !f.canonical {
+		return auth.HMACLegacyPayload
+	}
+	var names []string
+	for _, name := range strings.Split(f.signedHeaders, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return auth.HMACCanonicalPayload(names...)
+}
+
+// sign prints the signature headers or a curl command line for the request.
+func sign(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var req requestFlags
+	req.register(fs)
+	appID := fs.String("app-id", "", "`app ID` of the partner (required)")
+	secret := fs.String("secret", "", "shared `secret` of the app ID (required)")
+	nonce := fs.String("nonce", "", "`nonce` of the signature, a new UUID if empty")
+	timestamp := fs.String("timestamp", "", "unix `seconds` of the signature, now if empty")
+	algorithm := fs.String("algorithm", "",
+		"`hash:encoding` sent in the X-Auth-Algorithm header, e.g. sha256:base64, "+
+			"server default sha512:hex if empty")
+	curl := fs.Bool("curl", false, "print a curl command line instead of the headers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *appID == "" || *secret == "" {
+		return fmt.Errorf("flags -app-id and -secret are required")
+	}
+
+	alg := auth.DefaultHMACAlgorithm
+	if *algorithm != "" {
+		var err error
+		if alg, err = auth.ParseHMACAlgorithm(*algorithm); err != nil {
+			return err
+		}
+	}
+	if *nonce == "" {
+		*nonce = uuid.NewString()
+	}
+	if *timestamp == "" {
+		*timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	r, err := req.request()
+	if err != nil {
+		return err
+	}
+	payload, err := req.payload()(r, *nonce, *timestamp)
+	if err != nil {
+		return err
+	}
+	signature, err := alg.Sign([]byte(*secret), payload)
+	if err != nil {
+		return err
+	}
+
+	headers := [][2]string{
+		{auth.HMACHeaderAppID, *appID},
+		{auth.HMACHeaderNonce, *nonce},
+		{auth.HMACHeaderTimestamp, *timestamp},
+		{auth.HMACHeaderSignature, signature},
+	}
+	if *algorithm != "" {
+		headers = append(headers, [2]string{auth.HMACHeaderAlgorithm, alg.String()})
+	}
+
+	if !*curl {
+		for _, h := range headers {
+			fmt.Fprintf(stdout, "%s: %s\n", h[0], h[1])
+		}
+		return nil
+	}
+
+	cmd := []string{"curl", "-X", shellQuote(req.method)}
+	for _, h := range req.headers {
+		cmd = append(cmd, "-H", shellQuote(h))
+	}
+	for _, h := range headers {
+		cmd = append(cmd, "-H", shellQuote(h[0]+": "+h[1]))
+	}
+	if req.data != "" {
+		cmd = append(cmd, "--data-binary", shellQuote(req.data))
+	}
+	cmd = append(cmd, shellQuote(req.url))
+	fmt.Fprintln(stdout, strings.Join(cmd, " "))
+	return nil
+}
+
+// verify explains why the HMAC middleware would reject the request or
+// prints OK if it would pass.
+func verify(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var req requestFlags
+	req.register(fs)
+	appID := fs.String("app-id", "",
+		"`app ID` the secret is configured for, the X-Auth-App-ID header value if empty")
+	secret := fs.String("secret", "", "shared `secret` of the app ID (required)")
+	nonceExpiration := fs.Duration("nonce-expiration", auth.DefaultHMACNonceExpiration,
+		"nonce expiration of the middleware")
+	skew := fs.Duration("skew", 0, "clock skew of the middleware")
+	now := fs.Int64("now", 0, "unix `seconds` of the verification, now if 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *secret == "" {
+		return fmt.Errorf("flag -secret is required")
+	}
+
+	r, err := req.request()
+	if err != nil {
+		return err
+	}
+	if *appID == "" {
+		*appID = r.Header.Get(auth.HMACHeaderAppID)
+	}
+	clock := time.Now
+	if *now != 0 {
+		clock = func() time.Time { return time.Unix(*now, 0) }
+	}
+
+	// the nonce store is empty, replayed nonces can not be detected
+	h := auth.NewHMAC(
+		auth.WithHMACSecrets(map[string][]byte{*appID: []byte(*secret)}),
+		auth.WithHMACNonceExpiration(*nonceExpiration),
+		auth.WithHMACClockSkew(*skew),
+		auth.WithHMACClock(clock),
+		auth.WithHMACPayload(req.payload()),
+	)
+	p, err := h.Authenticate(r)
+	if err == nil {
+		fmt.Fprintf(stdout, "OK: signature of app ID '%s' is valid\n", p.AppID)
+		return nil
+	}
+
+	reason, ok := auth.HMACFailureReason(err)
+	if !ok {
+		return err
+	}
+	fmt.Fprintf(stdout, "FAIL %s: %v\n", string(reason), errors.Unwrap(err))
+	explainFailure(stdout, reason, r, &req, *appID, []byte(*secret))
+	return errVerify
+}
+
+// explainFailure prints a hint how to fix the failure.
+func explainFailure(
+	w io.Writer,
+	reason auth.HMACFailure,
+	r *http.Request,
+	req *requestFlags,
+	appID string,
+	secret []byte,
+) {
+
+	switch reason {
+	case auth.ErrHMACMissingHeader:
+		fmt.Fprintf(w, "hint: set all of the headers %s, %s, %s and %s\n",
+			auth.HMACHeaderAppID, auth.HMACHeaderNonce,
+			auth.HMACHeaderTimestamp, auth.HMACHeaderSignature)
+	case auth.ErrHMACUnknownAppID:
+		fmt.Fprintf(w, "hint: the secret is configured for app ID '%s', "+
+			"app IDs are case-sensitive\n", appID)
+	case auth.ErrHMACInvalidTimestamp:
+		fmt.Fprintln(w, "hint: the timestamp must be the current time in unix "+
+			"seconds, check the clock of the client")
+	case auth.ErrHMACExpired:
+		fmt.Fprintln(w, "hint: sign every request right before sending it, "+
+			"signatures can not be reused")
+	case auth.ErrHMACBodyTooLarge:
+		fmt.Fprintf(w, "hint: the server rejects bodies covered by the signature "+
+			"which are larger than %d bytes by default\n", auth.DefaultHMACMaxBodySize)
+	case auth.ErrHMACUnsupportedAlgorithm:
+		algs := auth.HMACAlgorithms()
+		names := make([]string, len(algs))
+		for i, alg := range algs {
+			names[i] = alg.String()
+		}
+		fmt.Fprintf(w, "hint: supported algorithms are %s\n", strings.Join(names, ", "))
+	case auth.ErrHMACInvalidSignature:
+		_, nonce, timestamp, signature := auth.GetHMACHeaders(r)
+		alg := auth.DefaultHMACAlgorithm
+		if header := r.Header.Get(auth.HMACHeaderAlgorithm); header != "" {
+			alg, _ = auth.ParseHMACAlgorithm(header)
+		}
+		payload, err := req.payload()(r, nonce, timestamp)
+		if err != nil {
+			return
+		}
+		expected, err := alg.Sign(secret, payload)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "app ID:             %s\n", appID)
+		fmt.Fprintf(w, "algorithm:          %s\n", alg)
+		fmt.Fprintf(w, "signed payload:     %q\n", payload)
+		fmt.Fprintf(w, "expected signature: %s\n", expected)
+		fmt.Fprintf(w, "actual signature:   %s\n", signature)
+		if !req.canonical {
+			fmt.Fprintln(w, "hint: the payload is nonce and timestamp, use -canonical "+
+				"if the server signs the full request")
+		}
+	}
+}
+
+// headerFlags are repeated "Name: value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if _, _, err := splitHeader(value); err != nil {
+		return err
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// splitHeader returns name and value of a "Name: value" header.
+func splitHeader(h string) (name, value string, err error) {
+	i := strings.Index(h, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("header '%s' is not 'Name: value'", h)
+	}
+	return strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]), nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
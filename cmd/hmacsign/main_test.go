@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/stretchr/testify/require"
+)
+
+// runCmd runs the command line and returns exit code and outputs.
+func runCmd(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// headerArgs returns the -H flags of the printed headers.
+func headerArgs(headers string) []string {
+	var args []string
+	for _, line := range strings.Split(strings.TrimSpace(headers), "\n") {
+		args = append(args, "-H", line)
+	}
+	return args
+}
+
+func TestSign(t *testing.T) {
+	code, stdout, _ := runCmd("sign", "-app-id", "some-app", "-secret", "some-secret",
+		"-nonce", "some-nonce", "-timestamp", "1700000000")
+	require.Equal(t, 0, code)
+	require.Equal(t, "X-Auth-App-ID: some-app\n"+
+		"X-Auth-Nonce: some-nonce\n"+
+		"X-Auth-Timestamp: 1700000000\n"+
+		"X-Auth-Signature: "+auth.HMACSign([]byte("some-secret"), []byte("some-nonce1700000000"))+"\n",
+		stdout)
+
+	// generated nonce and timestamp
+	code, stdout, _ = runCmd("sign", "-app-id", "some-app", "-secret", "some-secret",
+		"-algorithm", "sha256:base64")
+	require.Equal(t, 0, code)
+	require.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 5)
+	require.Contains(t, stdout, "X-Auth-Algorithm: sha256:base64\n")
+
+	// curl
+	code, stdout, _ = runCmd("sign", "-app-id", "some-app", "-secret", "some-secret",
+		"-curl", "-method", "POST", "-url", "https://api.example.com/orders?id=1",
+		"-H", "Content-Type: application/json", "-data", `{"note": "it's"}`, "-canonical")
+	require.Equal(t, 0, code)
+	require.True(t, strings.HasPrefix(stdout,
+		"curl -X POST -H 'Content-Type: application/json' -H 'X-Auth-App-ID: some-app' -H "))
+	require.True(t, strings.HasSuffix(stdout,
+		`--data-binary '{"note": "it'\''s"}' 'https://api.example.com/orders?id=1'`+"\n"))
+
+	for _, args := range [][]string{
+		{"sign", "-secret", "some-secret"},
+		{"sign", "-app-id", "some-app", "-secret", "some-secret", "-algorithm", "md4:hex"},
+		{"sign", "-app-id", "some-app", "-secret", "some-secret", "-H", "no header"},
+		{"sign", "-unknown"},
+		{"unknown"},
+		{},
+	} {
+		code, _, stderr := runCmd(args...)
+		require.Equal(t, 2, code, args)
+		require.NotEmpty(t, stderr, args)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	sign := func(args ...string) []string {
+		args = append([]string{"sign", "-app-id", "some-app", "-secret", "some-secret",
+			"-nonce", "some-nonce", "-timestamp", "1700000000"}, args...)
+		code, stdout, stderr := runCmd(args...)
+		require.Equal(t, 0, code, stderr)
+		return headerArgs(stdout)
+	}
+	verify := func(headers []string, args ...string) (int, string) {
+		args = append([]string{"verify", "-now", "1700000010"}, args...)
+		code, stdout, _ := runCmd(append(args, headers...)...)
+		return code, stdout
+	}
+
+	code, stdout := verify(sign(), "-secret", "some-secret")
+	require.Equal(t, 0, code)
+	require.Equal(t, "OK: signature of app ID 'some-app' is valid\n", stdout)
+
+	// canonical mode
+	request := []string{"-method", "PUT", "-url", "https://api.example.com/orders/1",
+		"-data", `{"id": 1}`, "-canonical"}
+	code, stdout = verify(append(sign(request...), request...), "-secret", "some-secret")
+	require.Equal(t, 0, code, stdout)
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+		args    []string
+		output  []string
+	}{
+		{
+			name:    "missing header",
+			headers: sign()[:2],
+			output:  []string{"FAIL missing_header: ", "hint: set all of the headers"},
+		},
+		{
+			name:    "unknown app ID",
+			headers: sign(),
+			args:    []string{"-app-id", "Some-App"},
+			output:  []string{"FAIL unknown_app_id: ", "configured for app ID 'Some-App'"},
+		},
+		{
+			name:    "expired",
+			headers: sign(),
+			args:    []string{"-now", "1700000200"},
+			output:  []string{"FAIL expired: ", "older than nonce expiration 2m0s"},
+		},
+		{
+			name:    "future",
+			headers: sign("-timestamp", "1700000100"),
+			output:  []string{"FAIL invalid_timestamp: ", "in the future"},
+		},
+		{
+			name:    "wrong secret",
+			headers: sign(),
+			args:    []string{"-secret", "other-secret"},
+			output: []string{
+				"FAIL invalid_signature: ",
+				`signed payload:     "some-nonce1700000000"`,
+				"expected signature: " + auth.HMACSign([]byte("other-secret"),
+					[]byte("some-nonce1700000000")),
+				"use -canonical",
+			},
+		},
+		{
+			name:    "canonical mismatch",
+			headers: sign(),
+			args:    []string{"-canonical"},
+			output:  []string{"FAIL invalid_signature: ", "signed payload:     \"GET\\n/\\n"},
+		},
+		{
+			name:    "unsupported algorithm",
+			headers: append(sign(), "-H", "X-Auth-Algorithm: md4:hex"),
+			output:  []string{"FAIL unsupported_algorithm: ", "sha512:hex"},
+		},
+	} {
+		args := append([]string{"-secret", "some-secret"}, tt.args...)
+		code, stdout := verify(tt.headers, args...)
+		require.Equal(t, 1, code, tt.name)
+		for _, s := range tt.output {
+			require.Contains(t, stdout, s, tt.name)
+		}
+	}
+
+	code, _, _ = runCmd("verify")
+	require.Equal(t, 2, code)
+}
@@ -39,6 +39,7 @@ const (
 	Unprocessable             // Unprocessable, invalid request data (422)
 	Internal                  // Internal server error (500)
 	BadGateway                // Bad gateway (502)
+	TooManyRequests           // Too many requests, rate limited (429)
 )
 
 // Separator defines the string used to separate nested errors.
@@ -67,6 +68,8 @@ func (k Kind) String() string {
 		return "internal error"
 	case BadGateway:
 		return "bad gateway"
+	case TooManyRequests:
+		return "too many requests"
 	}
 	return "unknown error kind"
 }
@@ -226,6 +229,8 @@ func ToHTTPStatus(e *Error) int {
 		status = http.StatusInternalServerError
 	case BadGateway:
 		status = http.StatusBadGateway
+	case TooManyRequests:
+		status = http.StatusTooManyRequests
 	default:
 		status = http.StatusInternalServerError
 	}
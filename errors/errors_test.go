@@ -95,17 +95,18 @@ func TestKind_String(t *testing.T) {
 		kind Kind
 		want string
 	}{
-		"Other":         {Other, "other error"},
-		"BadRequest":    {BadRequest, "bad request"},
-		"Unauthorized":  {Unauthorized, "unauthorized"},
-		"Forbidden":     {Forbidden, "forbidden"},
-		"NotFound":      {NotFound, "not found"},
-		"Conflict":      {Conflict, "conflict"},
-		"Gone":          {Gone, "gone"},
-		"Unprocessable": {Unprocessable, "unprocessable"},
-		"Internal":      {Internal, "internal error"},
-		"BadGateway":    {BadGateway, "bad gateway"},
-		"unknown":       {Kind(999), "unknown error kind"},
+		"Other":           {Other, "other error"},
+		"BadRequest":      {BadRequest, "bad request"},
+		"Unauthorized":    {Unauthorized, "unauthorized"},
+		"Forbidden":       {Forbidden, "forbidden"},
+		"NotFound":        {NotFound, "not found"},
+		"Conflict":        {Conflict, "conflict"},
+		"Gone":            {Gone, "gone"},
+		"Unprocessable":   {Unprocessable, "unprocessable"},
+		"Internal":        {Internal, "internal error"},
+		"BadGateway":      {BadGateway, "bad gateway"},
+		"TooManyRequests": {TooManyRequests, "too many requests"},
+		"unknown":         {Kind(999), "unknown error kind"},
 	}
 
 	for name, test := range tests {
@@ -159,6 +160,7 @@ func TestToHTTPStatus(t *testing.T) {
 		{"unprocessable", args{&Error{Kind: Unprocessable}}, 422},
 		{"internal", args{&Error{Kind: Internal}}, 500},
 		{"bad gateway", args{&Error{Kind: BadGateway}}, 502},
+		{"too many requests", args{&Error{Kind: TooManyRequests}}, 429},
 		{"unknown", args{&Error{Kind: Kind(999)}}, 500},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,109 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/iconimpact/go-core/auth"
+)
+
+// KeyFunc returns the key of the bucket a request is limited by. Requests
+// with an empty key are not limited.
+type KeyFunc func(r *http.Request) string
+
+// KeyByPrincipal limits requests per principal authenticated by the
+// middlewares of package auth, e.g. per app ID after auth.HMACMiddleware.
+// The key contains the kind of principal, "hmac", "jwt" or "apikey", so
+// principals of different schemes with the same ID do not share a bucket:
+//
+//	principal:hmac:<app ID>
+//
+// Requests without principal are not limited.
+func KeyByPrincipal(r *http.Request) string {
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || p.PrincipalID() == "" {
+		return ""
+	}
+	return "principal:" + principalKind(p) + ":" + p.PrincipalID()
+}
+
+// principalKind returns the kind of the principal providing the ID, the Go
+// type for principals not of package auth.
+func principalKind(p auth.Principal) string {
+	switch p := p.(type) {
+	case *auth.HMACPrincipal:
+		return "hmac"
+	case *auth.JWTClaims:
+		return "jwt"
+	case *auth.APIKey:
+		return "apikey"
+	case auth.Principals:
+		for _, principal := range p {
+			if principal.PrincipalID() != "" {
+				return principalKind(principal)
+			}
+		}
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// KeyByRemoteAddr limits requests per IP address of the client connection.
+// Behind a reverse proxy use KeyByHeader with the header set by the proxy.
+func KeyByRemoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		return ""
+	}
+	return "ip:" + host
+}
+
+// KeyByHeader returns a KeyFunc limiting requests per IP address of the
+// header set by a trusted reverse proxy, e.g. X-Real-IP or X-Forwarded-For
+// of which the first address is used. Requests without the header are not
+// limited.
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if i := strings.IndexByte(value, ','); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return ""
+		}
+		return "ip:" + value
+	}
+}
+
+// FirstKey returns a KeyFunc returning the first non-empty key of the
+// functions, e.g. FirstKey(KeyByPrincipal, KeyByRemoteAddr) limits
+// authenticated requests per principal and others per IP address.
+func FirstKey(funcs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, f := range funcs {
+			if key := f(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	require.Equal(t, "", ratelimit.KeyByPrincipal(r))
+	require.Equal(t, "ip:192.0.2.1", ratelimit.KeyByRemoteAddr(r))
+	require.Equal(t, "", ratelimit.KeyByHeader("X-Forwarded-For")(r))
+	require.Equal(t, "ip:192.0.2.1",
+		ratelimit.FirstKey(ratelimit.KeyByPrincipal, ratelimit.KeyByRemoteAddr)(r))
+
+	r.Header.Set("X-Forwarded-For", " 198.51.100.7, 10.0.0.1")
+	require.Equal(t, "ip:198.51.100.7", ratelimit.KeyByHeader("X-Forwarded-For")(r))
+
+	r = r.WithContext(auth.ContextWithHMACPrincipal(r.Context(),
+		&auth.HMACPrincipal{AppID: "some-app"}))
+	require.Equal(t, "principal:hmac:some-app", ratelimit.KeyByPrincipal(r))
+	require.Equal(t, "principal:hmac:some-app",
+		ratelimit.FirstKey(ratelimit.KeyByPrincipal, ratelimit.KeyByRemoteAddr)(r))
+
+	// principals of other schemes with the same ID have other keys
+	jwtRequest := r.WithContext(auth.ContextWithJWTClaims(context.Background(),
+		&auth.JWTClaims{Subject: "some-app"}))
+	require.Equal(t, "principal:jwt:some-app", ratelimit.KeyByPrincipal(jwtRequest))
+	apiKeyRequest := r.WithContext(auth.ContextWithPrincipal(context.Background(),
+		&auth.APIKey{Owner: "some-app"}))
+	combinedRequest := r.WithContext(auth.ContextWithPrincipal(r.Context(),
+		&auth.APIKey{Owner: "other-owner"}))
+	require.Equal(t, "principal:hmac:some-app", ratelimit.KeyByPrincipal(combinedRequest))
+	require.Equal(t, "principal:apikey:some-app", ratelimit.KeyByPrincipal(apiKeyRequest))
+
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	require.Equal(t, "ip:2001:db8::1", ratelimit.KeyByRemoteAddr(r))
+	r.RemoteAddr = "no-port"
+	require.Equal(t, "ip:no-port", ratelimit.KeyByRemoteAddr(r))
+
+	require.Equal(t, "", ratelimit.FirstKey()(r))
+}
@@ -0,0 +1,171 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/respond"
+	"go.uber.org/zap"
+)
+
+// Response headers set by the limiter. The RateLimit-* headers follow the
+// IETF draft "RateLimit header fields for HTTP".
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// Limiter limits HTTP requests with a token bucket per request key. It is
+// safe for concurrent use.
+type Limiter struct {
+	limit         Limit
+	limitFunc     func(r *http.Request, key string) Limit
+	key           KeyFunc
+	store         Store
+	now           func() time.Time
+	requestLogger func(r *http.Request) *zap.Logger
+	errorHandler  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewLimiter returns a new Limiter allowing requests at the limit configured
+// by the options. Without options requests are limited per IP address of the
+// client connection in a MemoryStore and rejected with respond.JSONError
+// without logging.
+func NewLimiter(limit Limit, opts ...Option) *Limiter {
+	l := &Limiter{
+		limit: limit,
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.key == nil {
+		l.key = KeyByRemoteAddr
+	}
+	if l.store == nil {
+		l.store = NewMemoryStore()
+	}
+	if l.errorHandler == nil {
+		l.errorHandler = l.respondError
+	}
+	return l
+}
+
+// Middleware limits requests by the key at the limit. Allowed requests are
+// passed to next with the RateLimit-* headers set, others are responded as
+// errors.TooManyRequests (429) with a Retry-After header in seconds, unless
+// the limit never adds tokens, using respond.JSONError. Store failures are responded as errors.Internal.
+// It is a shorthand for NewLimiter with the corresponding options, which are
+// applied before opts. requestLogger can be nil.
+func Middleware(
+	limit Limit,
+	key KeyFunc,
+	requestLogger func(r *http.Request) *zap.Logger,
+	opts ...Option,
+) func(next http.Handler) http.Handler {
+
+	opts = append([]Option{
+		WithKeyFunc(key),
+		WithRequestLogger(requestLogger),
+	}, opts...)
+
+	return NewLimiter(limit, opts...).Middleware
+}
+
+// Middleware returns an HTTP middleware passing only requests within the
+// limit to next. Failures are handled by the error handler.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.key(r)
+		res, limited, err := l.allow(r, key)
+		if err != nil {
+			l.errorHandler(w, r, err)
+			return
+		}
+		if limited {
+			setHeaders(w.Header(), res)
+		}
+		if !res.Allowed {
+			err := fmt.Errorf("rate limit exceeded: key '%s' has no tokens left", key)
+			if res.RetryAfter != Never {
+				w.Header().Set(HeaderRetryAfter, strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+				err = fmt.Errorf("rate limit exceeded: key '%s' must wait %s",
+					key, res.RetryAfter)
+			}
+			l.errorHandler(w, r, errors.E(err, errors.TooManyRequests, "too many requests"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Allow takes a token for the request and returns the result. Requests
+// having an empty key are allowed without taking a token and limited is
+// false. Store failures are returned as errors.Internal.
+func (l *Limiter) Allow(r *http.Request) (res Result, limited bool, err error) {
+	return l.allow(r, l.key(r))
+}
+
+func (l *Limiter) allow(r *http.Request, key string) (res Result, limited bool, err error) {
+	if key == "" {
+		return Result{Allowed: true}, false, nil
+	}
+
+	limit := l.limit
+	if l.limitFunc != nil {
+		limit = l.limitFunc(r, key)
+	}
+
+	res, err = l.store.Take(r.Context(), key, limit, l.now())
+	if err != nil {
+		err = fmt.Errorf("taking rate limit token of key '%s': %w", key, err)
+		return Result{}, false, errors.E(err, errors.Internal, "internal server error")
+	}
+	return res, true, nil
+}
+
+// respondError is the default error handler responding with
+// respond.JSONError.
+func (l *Limiter) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var log *zap.Logger
+	if l.requestLogger != nil {
+		log = l.requestLogger(r)
+	}
+	respond.JSONError(w, log, err)
+}
+
+// setHeaders sets the RateLimit-* headers of the result. RateLimit-Reset is
+// not set if the bucket is never refilled.
+func setHeaders(h http.Header, res Result) {
+	h.Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+	h.Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+	if res.Reset != Never {
+		h.Set(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(res.Reset), 10))
+	}
+}
+
+// ceilSeconds returns d in seconds rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
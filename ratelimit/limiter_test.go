@@ -0,0 +1,177 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iconimpact/go-core/auth"
+	"github.com/iconimpact/go-core/errors"
+	"github.com/iconimpact/go-core/ratelimit"
+	"github.com/iconimpact/go-core/testhelpers"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMiddleware(t *testing.T) {
+	logUnsugared, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	log, logs := testhelpers.ObserveLogs(t, logUnsugared.Sugar())
+
+	now := time.Unix(1700000000, 0)
+	handler := ratelimit.Middleware(
+		ratelimit.Limit{Rate: 1, Per: 10 * time.Second, Burst: 2},
+		ratelimit.KeyByRemoteAddr,
+		func(r *http.Request) *zap.Logger { return log.Desugar() },
+		ratelimit.WithClock(func() time.Time { return now }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get(ratelimit.HeaderRateLimitLimit))
+	require.Equal(t, "1", w.Header().Get(ratelimit.HeaderRateLimitRemaining))
+	require.Equal(t, "10", w.Header().Get(ratelimit.HeaderRateLimitReset))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRetryAfter))
+
+	// other port of the same IP address
+	w = serve("192.0.2.1:4321")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "0", w.Header().Get(ratelimit.HeaderRateLimitRemaining))
+
+	w = serve("192.0.2.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, `{"msg":"too many requests"}`, w.Body.String())
+	require.Equal(t, "10", w.Header().Get(ratelimit.HeaderRetryAfter))
+	require.Equal(t, "0", w.Header().Get(ratelimit.HeaderRateLimitRemaining))
+	require.Equal(t, "20", w.Header().Get(ratelimit.HeaderRateLimitReset))
+	testhelpers.RequireLastLogEntry(t, logs, zapcore.ErrorLevel, "",
+		map[string]string{"error": "rate limit exceeded: key 'ip:192.0.2.1'"})
+
+	// other IP address
+	require.Equal(t, http.StatusOK, serve("192.0.2.2:1234").Code)
+
+	// refilled
+	now = now.Add(5 * time.Second)
+	w = serve("192.0.2.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "5", w.Header().Get(ratelimit.HeaderRetryAfter))
+	now = now.Add(5 * time.Second)
+	require.Equal(t, http.StatusOK, serve("192.0.2.1:1234").Code)
+
+	// no Retry-After for limits which are never refilled
+	handler = ratelimit.Middleware(ratelimit.Limit{Burst: 1}, ratelimit.KeyByRemoteAddr, nil,
+		ratelimit.WithClock(func() time.Time { return now }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w = serve("192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRateLimitReset))
+	w = serve("192.0.2.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get(ratelimit.HeaderRateLimitRemaining))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRateLimitReset))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRetryAfter))
+}
+
+func TestMiddlewareHMAC(t *testing.T) {
+	secrets := map[string][]byte{
+		"partner-a": []byte("secret-a"),
+		"partner-b": []byte("secret-b"),
+	}
+	nonceExpiration := 2 * time.Second
+	hmacMiddleware := auth.HMACMiddleware(
+		secrets,
+		cache.New(nonceExpiration, nonceExpiration),
+		nonceExpiration,
+		nil,
+	)
+	limiter := ratelimit.Middleware(ratelimit.PerMinute(2), ratelimit.KeyByPrincipal, nil,
+		ratelimit.WithLimitFunc(func(r *http.Request, key string) ratelimit.Limit {
+			if key == "principal:hmac:partner-b" {
+				return ratelimit.PerMinute(3)
+			}
+			return ratelimit.PerMinute(2)
+		}))
+	handler := hmacMiddleware(limiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	serve := func(appID string) int {
+		nonce := uuid.NewString()
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		r := httptest.NewRequest("GET", "/", nil)
+		auth.SetHMACHeaders(r, appID, nonce, timestamp,
+			auth.HMACSign(secrets[appID], []byte(nonce+timestamp)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		appID  string
+		status int
+	}{
+		{"partner-a", http.StatusOK},
+		{"partner-a", http.StatusOK},
+		{"partner-a", http.StatusTooManyRequests},
+		{"partner-b", http.StatusOK},
+		{"partner-b", http.StatusOK},
+		{"partner-b", http.StatusOK},
+		{"partner-b", http.StatusTooManyRequests},
+	} {
+		require.Equal(t, tt.status, serve(tt.appID), tt.appID)
+	}
+
+	// requests without key are not limited
+	r := httptest.NewRequest("GET", "/", nil)
+	l := ratelimit.NewLimiter(ratelimit.PerMinute(1), ratelimit.WithKeyFunc(ratelimit.KeyByPrincipal))
+	for i := 0; i < 3; i++ {
+		res, limited, err := l.Allow(r)
+		require.NoError(t, err)
+		require.False(t, limited)
+		require.True(t, res.Allowed)
+	}
+}
+
+// storeFunc is a Store calling the function.
+type storeFunc func(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error)
+
+func (f storeFunc) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	return f(ctx, key, limit, now)
+}
+
+func TestLimiterStore(t *testing.T) {
+	var handled error
+	l := ratelimit.NewLimiter(ratelimit.PerSecond(1),
+		ratelimit.WithStore(storeFunc(
+			func(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+				return ratelimit.Result{}, fmt.Errorf("some store error")
+			})),
+		ratelimit.WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handled = err
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	_, _, err := l.Allow(r)
+	require.True(t, errors.IsKind(errors.Internal, err))
+	require.Contains(t, err.Error(), "some store error")
+
+	w := httptest.NewRecorder()
+	l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.True(t, errors.IsKind(errors.Internal, handled))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRateLimitLimit))
+}
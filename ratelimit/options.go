@@ -0,0 +1,79 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Option configures a Limiter created by NewLimiter or Middleware.
+type Option func(*Limiter)
+
+// WithKeyFunc sets the function returning the bucket key of a request.
+// Defaults to KeyByRemoteAddr.
+func WithKeyFunc(key KeyFunc) Option {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// WithStore sets the store of the buckets, e.g. a store shared by all
+// replicas of a service. Defaults to a new MemoryStore.
+func WithStore(store Store) Option {
+	return func(l *Limiter) {
+		l.store = store
+	}
+}
+
+// WithLimitFunc sets a function returning the limit of a request and its
+// key, e.g. for granting some partners a higher limit. It takes precedence
+// over the limit of NewLimiter.
+func WithLimitFunc(limit func(r *http.Request, key string) Limit) Option {
+	return func(l *Limiter) {
+		l.limitFunc = limit
+	}
+}
+
+// WithClock sets the function returning the current time, e.g. for testing.
+// Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// WithRequestLogger sets the function returning the logger of a request
+// which is used by the default error handler. Failures are not logged if it
+// is nil.
+func WithRequestLogger(requestLogger func(r *http.Request) *zap.Logger) Option {
+	return func(l *Limiter) {
+		l.requestLogger = requestLogger
+	}
+}
+
+// WithErrorHandler sets the function handling rejected requests and store
+// failures. The RateLimit-* and Retry-After headers are set before it is
+// called. Defaults to respond.JSONError.
+func WithErrorHandler(
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error),
+) Option {
+
+	return func(l *Limiter) {
+		l.errorHandler = errorHandler
+	}
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ratelimit provides a token bucket rate limiting HTTP middleware
+// keyed by authenticated principal, IP address or any other request key.
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit is the rate of a token bucket: Rate requests per Per duration with
+// bursts of up to Burst requests.
+type Limit struct {
+	// Rate is the number of tokens added per Per duration.
+	Rate int
+	// Per is the duration in which Rate tokens are added.
+	Per time.Duration
+	// Burst is the capacity of the bucket, Rate if 0.
+	Burst int
+}
+
+// PerSecond returns a limit of n requests per second.
+func PerSecond(n int) Limit {
+	return Limit{Rate: n, Per: time.Second}
+}
+
+// PerMinute returns a limit of n requests per minute.
+func PerMinute(n int) Limit {
+	return Limit{Rate: n, Per: time.Minute}
+}
+
+// PerHour returns a limit of n requests per hour.
+func PerHour(n int) Limit {
+	return Limit{Rate: n, Per: time.Hour}
+}
+
+// capacity returns the number of tokens of a full bucket.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Rate)
+}
+
+// Never is the RetryAfter and Reset of a Result if the bucket is never
+// refilled, i.e. the Rate or Per of its Limit is 0 or less.
+const Never = time.Duration(math.MaxInt64)
+
+// durationFor returns the duration until the bucket has gained tokens.
+func (l Limit) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if l.Rate <= 0 || l.Per <= 0 {
+		return Never
+	}
+	return time.Duration(math.Ceil(tokens * float64(l.Per) / float64(l.Rate)))
+}
+
+// Result is the result of taking a token from a bucket.
+type Result struct {
+	// Allowed reports whether a token was taken.
+	Allowed bool
+	// Limit is the capacity of the bucket.
+	Limit int
+	// Remaining is the number of tokens left.
+	Remaining int
+	// RetryAfter is the duration until the next token is available if the
+	// request was not allowed, otherwise 0. Never if no token is added.
+	RetryAfter time.Duration
+	// Reset is the duration until the bucket is full again, Never if no
+	// token is added.
+	Reset time.Duration
+}
+
+// Bucket is the state of a token bucket. Stores can use its Take method for
+// implementing the token bucket algorithm, shared stores must load, take and
+// save a bucket atomically.
+type Bucket struct {
+	// Tokens is the number of tokens at Updated.
+	Tokens float64
+	// Updated is the time of the last Take, zero for a new bucket.
+	Updated time.Time
+}
+
+// Take refills the bucket with the tokens added since the last call and
+// takes a token if one is available.
+func (b *Bucket) Take(l Limit, now time.Time) Result {
+	capacity := l.capacity()
+	b.refill(l, now)
+
+	res := Result{Limit: int(capacity)}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.durationFor(1 - b.Tokens)
+	}
+	res.Remaining = int(math.Floor(b.Tokens))
+	res.Reset = l.durationFor(capacity - b.Tokens)
+	return res
+}
+
+// Full reports whether the bucket is full at now, i.e. it does not need to
+// be stored any longer.
+func (b *Bucket) Full(l Limit, now time.Time) bool {
+	if b.Updated.IsZero() {
+		return true
+	}
+	return b.Tokens+l.tokensSince(b.Updated, now) >= l.capacity()
+}
+
+func (b *Bucket) refill(l Limit, now time.Time) {
+	capacity := l.capacity()
+	if b.Updated.IsZero() {
+		b.Tokens = capacity
+	} else {
+		b.Tokens = math.Min(capacity, b.Tokens+l.tokensSince(b.Updated, now))
+	}
+	// the clock of shared stores may go backwards between replicas
+	if now.After(b.Updated) {
+		b.Updated = now
+	}
+}
+
+// tokensSince returns the number of tokens added from t to now.
+func (l Limit) tokensSince(t, now time.Time) float64 {
+	elapsed := now.Sub(t)
+	if elapsed <= 0 || l.Per <= 0 {
+		return 0
+	}
+	return elapsed.Seconds() * float64(l.Rate) / l.Per.Seconds()
+}
@@ -0,0 +1,75 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketTake(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limit := ratelimit.Limit{Rate: 2, Per: time.Second, Burst: 3}
+
+	var b ratelimit.Bucket
+	require.True(t, b.Full(limit, now))
+
+	for i := 2; i >= 0; i-- {
+		res := b.Take(limit, now)
+		require.True(t, res.Allowed)
+		require.Equal(t, 3, res.Limit)
+		require.Equal(t, i, res.Remaining)
+		require.Zero(t, res.RetryAfter)
+	}
+	require.False(t, b.Full(limit, now))
+
+	res := b.Take(limit, now)
+	require.Equal(t, ratelimit.Result{
+		Limit:      3,
+		RetryAfter: 500 * time.Millisecond,
+		Reset:      1500 * time.Millisecond,
+	}, res)
+
+	// a token is added every 500ms
+	res = b.Take(limit, now.Add(250*time.Millisecond))
+	require.False(t, res.Allowed)
+	require.Equal(t, 250*time.Millisecond, res.RetryAfter)
+	res = b.Take(limit, now.Add(500*time.Millisecond))
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+
+	// refilled up to the burst
+	require.True(t, b.Full(limit, now.Add(2*time.Second)))
+	res = b.Take(limit, now.Add(time.Hour))
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, res.Remaining)
+	require.Equal(t, 500*time.Millisecond, res.Reset)
+
+	// clock going backwards adds no tokens
+	res = b.Take(limit, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+}
+
+func TestLimitConstructors(t *testing.T) {
+	require.Equal(t, ratelimit.Limit{Rate: 5, Per: time.Second}, ratelimit.PerSecond(5))
+	require.Equal(t, ratelimit.Limit{Rate: 5, Per: time.Minute}, ratelimit.PerMinute(5))
+	require.Equal(t, ratelimit.Limit{Rate: 5, Per: time.Hour}, ratelimit.PerHour(5))
+
+	// burst defaults to rate
+	var b ratelimit.Bucket
+	res := b.Take(ratelimit.PerMinute(60), time.Unix(1700000000, 0))
+	require.Equal(t, 60, res.Limit)
+	require.Equal(t, 59, res.Remaining)
+	require.Equal(t, time.Second, res.Reset)
+
+	// zero rate denies once the burst is used
+	b = ratelimit.Bucket{}
+	limit := ratelimit.Limit{Burst: 1}
+	require.True(t, b.Take(limit, time.Unix(1700000000, 0)).Allowed)
+	res = b.Take(limit, time.Unix(1800000000, 0))
+	require.False(t, res.Allowed)
+	require.Equal(t, ratelimit.Never, res.RetryAfter)
+	require.Equal(t, ratelimit.Never, res.Reset)
+}
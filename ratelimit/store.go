@@ -0,0 +1,90 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store is an interface abstracting away the storage of token buckets. A
+// store shared by all replicas of a service, e.g. Redis or a database,
+// limits requests across all of them.
+type Store interface {
+	// Take takes a token from the bucket of the key with the limit at now.
+	// Loading and saving the bucket must be atomic, see Bucket.Take.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// DefaultSweepInterval is the interval in which MemoryStore removes full
+// buckets.
+const DefaultSweepInterval = time.Minute
+
+// MemoryStore is a Store keeping the buckets in memory, so every replica of
+// a service limits requests on its own. Full buckets are removed every
+// DefaultSweepInterval. It is safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket is a bucket with the limit it was last taken with, needed
+// for sweeping.
+type memoryBucket struct {
+	Bucket
+	limit Limit
+}
+
+// NewMemoryStore returns a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryBucket{}}
+}
+
+// Take takes a token from the bucket of the key.
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= DefaultSweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	return b.Take(limit, now), nil
+}
+
+// Len returns the number of stored buckets.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// sweep removes the full buckets. The lock must be held.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.Full(b.limit, now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,60 @@
+package ratelimit_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iconimpact/go-core/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	limit := ratelimit.PerSecond(1)
+	s := ratelimit.NewMemoryStore()
+
+	res, err := s.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	res, err = s.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+
+	// buckets are separate per key
+	res, err = s.Take(ctx, "b", ratelimit.PerHour(10), now)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, s.Len())
+
+	// full buckets are swept, bucket b is not full yet
+	_, err = s.Take(ctx, "c", limit, now.Add(ratelimit.DefaultSweepInterval))
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Len())
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := ratelimit.NewMemoryStore()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.Take(context.Background(), "a", ratelimit.PerMinute(20), now)
+			require.NoError(t, err)
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 20, allowed)
+}
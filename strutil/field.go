@@ -0,0 +1,144 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoFieldKeyring is returned by EncryptedString if no keyring was set
+// with SetFieldKeyring.
+var ErrNoFieldKeyring = errors.New("no keyring set for encrypted fields")
+
+var (
+	fieldMu      sync.RWMutex
+	fieldKeyring *Keyring
+)
+
+// SetFieldKeyring sets the keyring EncryptedString encrypts and decrypts
+// with, e.g. on startup.
+func SetFieldKeyring(k *Keyring) {
+	fieldMu.Lock()
+	fieldKeyring = k
+	fieldMu.Unlock()
+}
+
+func getFieldKeyring() (*Keyring, error) {
+	fieldMu.RLock()
+	k := fieldKeyring
+	fieldMu.RUnlock()
+	if k == nil {
+		return nil, ErrNoFieldKeyring
+	}
+	return k, nil
+}
+
+// EncryptedString is a string which is stored encrypted in databases and
+// JSON, e.g. for PII columns. It is encrypted with the keyring of
+// SetFieldKeyring when written by database/sql or json.Marshal and
+// decrypted when read, so it is plaintext in Go:
+//
+//	type User struct {
+//		ID        int64
+//		Email     strutil.EncryptedString
+//		EmailHash string // BlindIndex of Email for lookups
+//	}
+//
+// Convert it to string for returning the plaintext in API responses. Use a
+// pointer for nullable columns.
+type EncryptedString string
+
+// Value encrypts the string for database/sql.
+func (s EncryptedString) Value() (driver.Value, error) {
+	k, err := getFieldKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(string(s))
+}
+
+// Scan decrypts a string or []byte of Value, NULL is scanned as "".
+func (s *EncryptedString) Scan(src interface{}) error {
+	var encr string
+	switch src := src.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		encr = src
+	case []byte:
+		encr = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedString", src)
+	}
+	return s.decrypt(encr)
+}
+
+// MarshalJSON encrypts the string into a JSON string.
+func (s EncryptedString) MarshalJSON() ([]byte, error) {
+	k, err := getFieldKeyring()
+	if err != nil {
+		return nil, err
+	}
+	encr, err := k.Encrypt(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encr)
+}
+
+// UnmarshalJSON decrypts a JSON string of MarshalJSON, null is unmarshaled
+// as "".
+func (s *EncryptedString) UnmarshalJSON(data []byte) error {
+	var encr *string
+	if err := json.Unmarshal(data, &encr); err != nil {
+		return err
+	}
+	if encr == nil {
+		*s = ""
+		return nil
+	}
+	return s.decrypt(*encr)
+}
+
+func (s *EncryptedString) decrypt(encr string) error {
+	k, err := getFieldKeyring()
+	if err != nil {
+		return err
+	}
+	text, err := k.Decrypt(encr)
+	if err != nil {
+		return fmt.Errorf("decrypting EncryptedString: %w", err)
+	}
+	*s = EncryptedString(text)
+	return nil
+}
+
+// BlindIndex returns a keyed hash of the text using Hash, which can be stored
+// next to an EncryptedString column for looking it up by equality, as the
+// ciphertexts of equal strings differ. The key must be secret with at least
+// 256 bit (32 bytes) and differ per column, e.g. derived with DeriveKey, so
+// the index can not be computed for guessed values without it. Normalize the
+// text before, e.g. strings.ToLower(strings.TrimSpace(email)).
+func BlindIndex(text, key string) (string, error) {
+	if len(key) < keySize {
+		return "", &InvalidKeyError{Len: len(key), Reason: "blind index key needs at least 256 bit (32 bytes)"}
+	}
+	return Hash(text, key), nil
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedString(t *testing.T) {
+	defer SetFieldKeyring(nil)
+	email := EncryptedString("jane@example.com")
+
+	_, err := email.Value()
+	assert.True(t, errors.Is(err, ErrNoFieldKeyring))
+
+	k, err := NewKeyring(EncryptionKey{ID: "k1", Key: []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")})
+	assert.Nil(t, err)
+	SetFieldKeyring(k)
+
+	// database/sql
+	v, err := email.Value()
+	assert.Nil(t, err)
+	encr, ok := v.(string)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(encr, "v1.k1."))
+	assert.NotContains(t, encr, "jane")
+
+	var scanned EncryptedString
+	assert.Nil(t, scanned.Scan(encr))
+	assert.Equal(t, email, scanned)
+	scanned = ""
+	assert.Nil(t, scanned.Scan([]byte(encr)))
+	assert.Equal(t, email, scanned)
+	assert.Nil(t, scanned.Scan(nil))
+	assert.Equal(t, EncryptedString(""), scanned)
+	assert.NotNil(t, scanned.Scan(42))
+	assert.NotNil(t, scanned.Scan("v1.k1.00"))
+
+	// JSON
+	type user struct {
+		Email EncryptedString `json:"email"`
+	}
+	data, err := json.Marshal(user{Email: email})
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "jane")
+	var u user
+	assert.Nil(t, json.Unmarshal(data, &u))
+	assert.Equal(t, email, u.Email)
+	assert.Nil(t, json.Unmarshal([]byte(`{"email":null}`), &u))
+	assert.Equal(t, EncryptedString(""), u.Email)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"email":"jane@example.com"}`), &u))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"email":42}`), &u))
+}
+
+func TestBlindIndex(t *testing.T) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+
+	index, err := BlindIndex("jane@example.com", key)
+	assert.Nil(t, err)
+	assert.Equal(t, Hash("jane@example.com", key), index)
+	again, err := BlindIndex("jane@example.com", key)
+	assert.Nil(t, err)
+	assert.Equal(t, index, again)
+
+	other, err := BlindIndex("jane@example.com", "8JtsDUxzjp372XxNKypHB7zQbnjUBBoG")
+	assert.Nil(t, err)
+	assert.NotEqual(t, index, other)
+
+	_, err = BlindIndex("jane@example.com", "too short")
+	var keyErr *InvalidKeyError
+	assert.True(t, errors.As(err, &keyErr))
+}
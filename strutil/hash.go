@@ -16,7 +16,6 @@ package strutil
 
 import (
 	"crypto/hmac"
-	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
 )
@@ -38,10 +37,3 @@ func Hash(text string, description string) string {
 	}
 	return hash
 }
-
-func sha256Hash(text string) string {
-	hash := sha256.New()
-	hash.Write([]byte(text))
-	md := hash.Sum(nil)
-	return hex.EncodeToString(md)
-}
@@ -0,0 +1,135 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// keySize is the size of encryption keys in bytes.
+const keySize = 32
+
+// InvalidKeyError is returned for invalid key material, e.g. an encryption
+// key which is not 256 bit. Use DeriveKey or NewPassphraseKey to get a valid
+// key from other key material.
+type InvalidKeyError struct {
+	// Len is the length of the key in bytes.
+	Len int
+	// Reason describes the requirement the key does not meet.
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key of %d bytes: %s", e.Len, e.Reason)
+}
+
+// checkEncryptionKey returns an *InvalidKeyError if the key is not 256 bit.
+func checkEncryptionKey(key []byte) error {
+	if len(key) != keySize {
+		return &InvalidKeyError{Len: len(key), Reason: "encryption key needs 256 bit (32 bytes)"}
+	}
+	return nil
+}
+
+// DeriveKey derives an independent 256-bit encryption key for the purpose,
+// e.g. "users.email", from the master key using HKDF-SHA-256 (RFC 5869), so
+// a single master key can be configured instead of a key per use. The same
+// master key and purpose always result in the same key. The master key must
+// be random with at least 256 bit (32 bytes), e.g. generated with
+// RandomString(64, AlphabetAlphaNumeric), for passphrases use
+// NewPassphraseKey.
+func DeriveKey(masterKey, purpose string) (string, error) {
+	if len(masterKey) < keySize {
+		return "", &InvalidKeyError{Len: len(masterKey), Reason: "master key needs at least 256 bit (32 bytes)"}
+	}
+	if purpose == "" {
+		return "", errors.New("key purpose must not be empty")
+	}
+	return string(hkdfSHA256([]byte(masterKey), nil, []byte(purpose), keySize)), nil
+}
+
+// NewPassphraseKey derives a 256-bit encryption key from the passphrase with
+// a new random salt using PBKDF2-SHA-512 with the iterations of
+// DefaultPBKDF2. It returns the key and its parameters, which are not secret
+// and must be stored, e.g. next to the encrypted data, to derive the same key
+// with PassphraseKey again:
+//
+//	$pbkdf2-sha512$i=<iterations>$<base64(salt)>
+func NewPassphraseKey(passphrase string) (key, params string, err error) {
+	salt := make([]byte, DefaultPBKDF2.SaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", "", err
+	}
+	params = fmt.Sprintf("$%s$i=%d$%s", DefaultPBKDF2.ID(), DefaultPBKDF2.Iterations,
+		base64.RawStdEncoding.EncodeToString(salt))
+	key, err = PassphraseKey(passphrase, params)
+	if err != nil {
+		return "", "", err
+	}
+	return key, params, nil
+}
+
+// PassphraseKey derives the 256-bit encryption key of NewPassphraseKey from
+// the passphrase and the stored parameters.
+func PassphraseKey(passphrase, params string) (string, error) {
+	if passphrase == "" {
+		return "", &InvalidKeyError{Reason: "passphrase must not be empty"}
+	}
+	parts := strings.Split(params, "$")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != DefaultPBKDF2.ID() ||
+		!strings.HasPrefix(parts[2], "i=") {
+		return "", errors.New("malformed passphrase key parameters")
+	}
+	iterations, err := strconv.Atoi(parts[2][len("i="):])
+	if err != nil || iterations < 1 || iterations > pbkdf2MaxIterations {
+		return "", errors.New("malformed passphrase key iterations")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(salt) == 0 {
+		return "", errors.New("malformed passphrase key salt")
+	}
+	return string(pbkdf2SHA512([]byte(passphrase), salt, iterations, keySize)), nil
+}
+
+// hkdfSHA256 derives a key of length bytes from the secret, salt and info
+// with HKDF (RFC 5869) using HMAC-SHA-256.
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	// PRK = HMAC(salt, secret), an empty salt is equal to zeros
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	// T(n) = HMAC(PRK, T(n-1) || info || n)
+	expand := hmac.New(sha256.New, prk)
+	okm := make([]byte, 0, length+expand.Size())
+	var t []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		t = expand.Sum(t[:0])
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHKDFSHA256(t *testing.T) {
+	// RFC 5869, test cases 1 and 3
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+
+	got := hkdfSHA256(secret, salt, info, 42)
+	assert.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"+
+		"34007208d5b887185865", hex.EncodeToString(got))
+	got = hkdfSHA256(secret, nil, nil, 42)
+	assert.Equal(t, "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d"+
+		"9d201395faa4b61a96c8", hex.EncodeToString(got))
+}
+
+func TestDeriveKey(t *testing.T) {
+	text := " My dark, little Secret 🔐 "
+	masterKey := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+
+	key, err := DeriveKey(masterKey, "users.email")
+	assert.Nil(t, err)
+	assert.Len(t, key, 32)
+	again, err := DeriveKey(masterKey, "users.email")
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+	other, err := DeriveKey(masterKey, "users.phone")
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, other)
+
+	encr, err := Encrypt(text, key)
+	assert.Nil(t, err)
+	decr, err := Decrypt(encr, again)
+	assert.Nil(t, err)
+	assert.Equal(t, text, decr)
+
+	_, err = DeriveKey(masterKey[:31], "users.email")
+	var keyErr *InvalidKeyError
+	assert.True(t, errors.As(err, &keyErr))
+	assert.Equal(t, 31, keyErr.Len)
+	_, err = DeriveKey(masterKey, "")
+	assert.NotNil(t, err)
+}
+
+func TestPassphraseKey(t *testing.T) {
+	passphrase := "correct horse battery staple"
+
+	key, params, err := NewPassphraseKey(passphrase)
+	assert.Nil(t, err)
+	assert.Len(t, key, 32)
+	assert.True(t, strings.HasPrefix(params, "$pbkdf2-sha512$i=210000$"))
+
+	again, err := PassphraseKey(passphrase, params)
+	assert.Nil(t, err)
+	assert.Equal(t, key, again)
+	wrong, err := PassphraseKey(passphrase+"x", params)
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, wrong)
+
+	// new salt per key
+	key2, params2, err := NewPassphraseKey(passphrase)
+	assert.Nil(t, err)
+	assert.NotEqual(t, params, params2)
+	assert.NotEqual(t, key, key2)
+
+	for _, malformed := range []string{
+		"",
+		"$pbkdf2-sha512$i=1000",
+		"$pbkdf2-sha256$i=1000$c2FsdA",
+		"$pbkdf2-sha512$i=0$c2FsdA",
+		"$pbkdf2-sha512$i=1000$!",
+		"$pbkdf2-sha512$i=1000$c2FsdA$aGFzaA",
+	} {
+		key, err := PassphraseKey(passphrase, malformed)
+		assert.NotNil(t, err, malformed)
+		assert.Empty(t, key, malformed)
+	}
+
+	_, _, err = NewPassphraseKey("")
+	var keyErr *InvalidKeyError
+	assert.True(t, errors.As(err, &keyErr))
+}
+
+func TestInvalidKeyError(t *testing.T) {
+	var keyErr *InvalidKeyError
+
+	_, err := Encrypt("text", "too short")
+	assert.True(t, errors.As(err, &keyErr))
+	assert.Equal(t, 9, keyErr.Len)
+	_, err = Decrypt("00", "too short")
+	assert.True(t, errors.As(err, &keyErr))
+	_, err = EncryptDeterministic("text", "too short", nil, EncodingHex)
+	assert.True(t, errors.As(err, &keyErr))
+	_, err = NewKeyring(EncryptionKey{ID: "k1", Key: []byte("too short")})
+	assert.True(t, errors.As(err, &keyErr))
+	_, err = NewEncryptWriter(&bytes.Buffer{}, "too short")
+	assert.True(t, errors.As(err, &keyErr))
+
+	// the string and stream APIs accept all AES key sizes for compatibility
+	for _, key := range []string{"0123456789abcdef", "0123456789abcdef01234567"} {
+		encr, err := Encrypt("hello", key)
+		assert.Nil(t, err, key)
+		decr, err := Decrypt(encr, key)
+		assert.Nil(t, err, key)
+		assert.Equal(t, "hello", decr, key)
+		encr, err = EncryptWithAD("hello", key, []byte("ad"), EncodingBase64URL)
+		assert.Nil(t, err, key)
+		decr, err = DecryptWithAD(encr, key, []byte("ad"), EncodingBase64URL)
+		assert.Nil(t, err, key)
+		assert.Equal(t, "hello", decr, key)
+		var encrStream, decrStream bytes.Buffer
+		assert.Nil(t, EncryptStream(&encrStream, strings.NewReader("hello"), key), key)
+		assert.Nil(t, DecryptStream(&decrStream, &encrStream, key), key)
+		assert.Equal(t, "hello", decrStream.String(), key)
+
+		// the newer APIs need 256 bit keys
+		_, err = EncryptDeterministic("hello", key, nil, EncodingHex)
+		assert.True(t, errors.As(err, &keyErr), key)
+		_, err = NewKeyring(EncryptionKey{ID: "k1", Key: []byte(key)})
+		assert.True(t, errors.As(err, &keyErr), key)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnknownKeyID is returned by Keyring.Decrypt for ciphertexts encrypted
+// with a key which is not in the keyring.
+var ErrUnknownKeyID = errors.New("unknown encryption key ID")
+
+// envelopeVersion is the format version prefix of keyring ciphertexts.
+const envelopeVersion = "v1"
+
+// maxKeyIDLen is the maximum length of encryption key IDs in bytes, as the
+// header of encrypted streams stores it in one byte.
+const maxKeyIDLen = 255
+
+// EncryptionKey is a 256-bit AES key of a Keyring.
+type EncryptionKey struct {
+	// ID identifies the key in ciphertexts, it must only contain the
+	// characters A-Z a-z 0-9 - _, have at most 255 characters and is not
+	// secret.
+	ID string
+	// Key is the 256 bit (32 bytes) AES key.
+	Key []byte
+}
+
+// keyringKey is a key with its AEAD.
+type keyringKey struct {
+	id   string
+	key  []byte
+	aead cipher.AEAD
+}
+
+// Keyring encrypts data using 256-bit AES-GCM with its primary key and
+// decrypts data encrypted with any of its keys, so keys can be rotated
+// without re-encrypting all data at once. Ciphertexts are versioned
+// envelopes naming the key:
+//
+//	v1.<key ID>.<hex(nonce || ciphertext)>
+//
+// Version and key ID are authenticated as additional data. It also decrypts
+// the legacy format of Encrypt by trying all keys. It is safe for
+// concurrent use.
+type Keyring struct {
+	keys []keyringKey
+}
+
+// NewKeyring returns a new Keyring encrypting with the first of the keys,
+// the primary key, and decrypting with all of them.
+func NewKeyring(keys ...EncryptionKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+	k := &Keyring{keys: make([]keyringKey, 0, len(keys))}
+	for _, key := range keys {
+		if !validKeyID(key.ID) {
+			return nil, fmt.Errorf("encryption key ID '%s' must only contain A-Z a-z 0-9 - _", key.ID)
+		}
+		if len(key.ID) > maxKeyIDLen {
+			return nil, fmt.Errorf("encryption key ID '%s' is longer than %d characters", key.ID, maxKeyIDLen)
+		}
+		if _, ok := k.key(key.ID); ok {
+			return nil, fmt.Errorf("encryption key ID '%s' is not unique", key.ID)
+		}
+		if err := checkEncryptionKey(key.Key); err != nil {
+			return nil, fmt.Errorf("encryption key '%s': %w", key.ID, err)
+		}
+		aead, err := newGCM(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, keyringKey{id: key.ID, key: key.Key, aead: aead})
+	}
+	return k, nil
+}
+
+// PrimaryKeyID returns the ID of the key used for encrypting.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.keys[0].id
+}
+
+// Encrypt encrypts the text with the primary key and returns the envelope.
+func (k *Keyring) Encrypt(text string) (string, error) {
+	key := k.keys[0]
+	prefix := envelopeVersion + "." + key.id + "."
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	seal := key.aead.Seal(nonce, nonce, []byte(text), []byte(prefix))
+	return prefix + hex.EncodeToString(seal), nil
+}
+
+// Decrypt decrypts an envelope of Encrypt with the key it names or a legacy
+// ciphertext of strutil.Encrypt with any of the keys. It returns
+// ErrUnknownKeyID if the key is not in the keyring.
+func (k *Keyring) Decrypt(encryptedText string) (string, error) {
+	if !strings.HasPrefix(encryptedText, envelopeVersion+".") {
+		return k.decryptLegacy(encryptedText)
+	}
+
+	parts := strings.SplitN(encryptedText, ".", 3)
+	if len(parts) != 3 {
+		return "", errors.New("malformed ciphertext")
+	}
+	key, ok := k.key(parts[1])
+	if !ok {
+		return "", fmt.Errorf("%w '%s'", ErrUnknownKeyID, parts[1])
+	}
+	ciphertext, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	return open(key.aead, ciphertext, []byte(parts[0]+"."+parts[1]+"."))
+}
+
+// NeedsReencrypt reports whether the ciphertext is not encrypted with the
+// primary key or in the legacy format, so it should be re-encrypted after
+// rotating keys.
+func (k *Keyring) NeedsReencrypt(encryptedText string) bool {
+	return !strings.HasPrefix(encryptedText, envelopeVersion+"."+k.keys[0].id+".")
+}
+
+// decryptLegacy decrypts a ciphertext of strutil.Encrypt with the first
+// matching key.
+func (k *Keyring) decryptLegacy(encryptedText string) (string, error) {
+	ciphertext, err := hex.DecodeString(encryptedText)
+	if err != nil {
+		return "", err
+	}
+	err = errors.New("malformed ciphertext")
+	for _, key := range k.keys {
+		var text string
+		text, err = open(key.aead, ciphertext, nil)
+		if err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+func (k *Keyring) key(id string) (keyringKey, bool) {
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return keyringKey{}, false
+}
+
+// newGCM returns AES-GCM with the 128, 192 or 256 bit key, it returns an
+// *InvalidKeyError for other keys.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkAESKey returns an *InvalidKeyError if the key is not 128, 192 or 256
+// bit, as accepted by Encrypt for compatibility.
+func checkAESKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return &InvalidKeyError{Len: len(key), Reason: "AES key needs 128, 192 or 256 bit (16, 24 or 32 bytes)"}
+}
+
+// open decrypts nonce || ciphertext.
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) (string, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return "", errors.New("malformed ciphertext")
+	}
+	decr, err := aead.Open(nil,
+		ciphertext[:aead.NonceSize()],
+		ciphertext[aead.NonceSize():],
+		additionalData,
+	)
+	if err != nil {
+		return "", err
+	}
+	return string(decr), nil
+}
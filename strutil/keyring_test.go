@@ -0,0 +1,93 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	text := " My dark, little Secret 🔐 "
+	key1 := EncryptionKey{ID: "k1", Key: []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")}
+	key2 := EncryptionKey{ID: "k2", Key: []byte("8JtsDUxzjp372XxNKypHB7zQbnjUBBoG")}
+
+	k, err := NewKeyring(key1)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", k.PrimaryKeyID())
+
+	encr, err := k.Encrypt(text)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encr, "v1.k1."))
+	assert.False(t, k.NeedsReencrypt(encr))
+	decr, err := k.Decrypt(encr)
+	assert.Nil(t, err)
+	assert.Equal(t, text, decr)
+
+	// rotated: old ciphertexts are decrypted, new ones use the primary key
+	rotated, err := NewKeyring(key2, key1)
+	assert.Nil(t, err)
+	assert.True(t, rotated.NeedsReencrypt(encr))
+	decr, err = rotated.Decrypt(encr)
+	assert.Nil(t, err)
+	assert.Equal(t, text, decr)
+
+	encr2, err := rotated.Encrypt(text)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encr2, "v1.k2."))
+	_, err = k.Decrypt(encr2)
+	assert.True(t, errors.Is(err, ErrUnknownKeyID))
+
+	// key ID is authenticated
+	other, err := NewKeyring(EncryptionKey{ID: "k3", Key: key1.Key})
+	assert.Nil(t, err)
+	_, err = other.Decrypt("v1.k3." + strings.TrimPrefix(encr, "v1.k1."))
+	assert.NotNil(t, err)
+
+	// legacy format
+	legacy, err := Encrypt(text, string(key1.Key))
+	assert.Nil(t, err)
+	assert.True(t, rotated.NeedsReencrypt(legacy))
+	decr, err = rotated.Decrypt(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, text, decr)
+	_, err = other.Decrypt(legacy + "00")
+	assert.NotNil(t, err)
+
+	for _, malformed := range []string{"", "v1.k1", "v1.k1.xyz", "v1.k1.00", "xyz"} {
+		decr, err = k.Decrypt(malformed)
+		assert.NotNil(t, err, malformed)
+		assert.Empty(t, decr, malformed)
+	}
+}
+
+func TestNewKeyringInvalidKeys(t *testing.T) {
+	key := []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")
+	for name, keys := range map[string][]EncryptionKey{
+		"none":         nil,
+		"empty ID":     {{Key: key}},
+		"invalid ID":   {{ID: "k.1", Key: key}},
+		"duplicate ID": {{ID: "k1", Key: key}, {ID: "k1", Key: key}},
+		"short key":    {{ID: "k1", Key: key[:16]}},
+		"long ID":      {{ID: strings.Repeat("k", 256), Key: key}},
+	} {
+		k, err := NewKeyring(keys...)
+		assert.NotNil(t, err, name)
+		assert.Nil(t, k, name)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownPasswordHasher is returned for password hashes of algorithms
+// which are not registered with RegisterPasswordHasher.
+var ErrUnknownPasswordHasher = errors.New("unknown password hash algorithm")
+
+// PasswordHasher hashes passwords into self-describing PHC strings
+// ($<id>$<params>$<salt>$<hash>) of one algorithm. Implementations for other
+// algorithms like Argon2id or bcrypt can be added with
+// RegisterPasswordHasher.
+type PasswordHasher interface {
+	// ID returns the PHC algorithm identifier, e.g. "pbkdf2-sha512".
+	ID() string
+	// Hash returns the PHC string of the password with a new random salt.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the PHC string. It
+	// returns an error if the PHC string is malformed.
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash reports whether the PHC string was created with other
+	// parameters than the ones of the hasher.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	passwordMu      sync.RWMutex
+	passwordHashers = map[string]PasswordHasher{}
+	passwordHasher  PasswordHasher
+)
+
+func init() {
+	SetPasswordHasher(DefaultPBKDF2)
+}
+
+// RegisterPasswordHasher registers the hasher for verifying hashes of its
+// algorithm ID, replacing a hasher having the same ID.
+func RegisterPasswordHasher(h PasswordHasher) {
+	passwordMu.Lock()
+	passwordHashers[h.ID()] = h
+	passwordMu.Unlock()
+}
+
+// SetPasswordHasher registers the hasher and sets it as the hasher of
+// HashPassword. Defaults to DefaultPBKDF2. Hashes of other algorithms or
+// parameters can still be verified, NeedsRehash reports them.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordMu.Lock()
+	passwordHashers[h.ID()] = h
+	passwordHasher = h
+	passwordMu.Unlock()
+}
+
+// HashPassword returns the PHC string of the password for storing it, e.g.
+// "$pbkdf2-sha512$i=210000$<salt>$<hash>" of DefaultPBKDF2.
+func HashPassword(password string) (string, error) {
+	passwordMu.RLock()
+	h := passwordHasher
+	passwordMu.RUnlock()
+	return h.Hash(password)
+}
+
+// VerifyPassword reports whether the password matches the PHC string hash
+// in constant time. It returns ErrUnknownPasswordHasher if the algorithm of
+// the hash is not registered and an error if the hash is malformed.
+func VerifyPassword(password, hash string) (bool, error) {
+	h, err := passwordHasherOf(hash)
+	if err != nil {
+		return false, err
+	}
+	return h.Verify(password, hash)
+}
+
+// NeedsRehash reports whether the hash was not created by the hasher of
+// HashPassword with its current parameters, e.g. after increasing the
+// iterations. Such hashes should be replaced with HashPassword after the
+// next successful VerifyPassword, as only then the password is known.
+func NeedsRehash(hash string) bool {
+	passwordMu.RLock()
+	h := passwordHasher
+	passwordMu.RUnlock()
+	return phcID(hash) != h.ID() || h.NeedsRehash(hash)
+}
+
+// passwordHasherOf returns the registered hasher of the hash algorithm.
+func passwordHasherOf(hash string) (PasswordHasher, error) {
+	id := phcID(hash)
+	passwordMu.RLock()
+	h, ok := passwordHashers[id]
+	passwordMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownPasswordHasher, id)
+	}
+	return h, nil
+}
+
+// phcID returns the algorithm ID of a PHC string.
+func phcID(hash string) string {
+	if !strings.HasPrefix(hash, "$") {
+		return ""
+	}
+	hash = hash[1:]
+	if i := strings.IndexByte(hash, '$'); i >= 0 {
+		return hash[:i]
+	}
+	return hash
+}
+
+// PBKDF2 is a PasswordHasher using PBKDF2 (RFC 8018) with HMAC-SHA-512.
+// Hashes look like "$pbkdf2-sha512$i=<iterations>$<salt>$<hash>" with salt
+// and hash in unpadded standard base64.
+type PBKDF2 struct {
+	// Iterations is the cost, the higher the slower.
+	Iterations int
+	// SaltLen is the length of the random salt in bytes.
+	SaltLen int
+	// KeyLen is the length of the hash in bytes.
+	KeyLen int
+}
+
+// DefaultPBKDF2 is the default PasswordHasher with 210000 iterations as
+// recommended by OWASP for PBKDF2-HMAC-SHA-512, a 16 byte salt and a 64
+// byte hash. Use the benchmarks to tune the iterations for your servers.
+var DefaultPBKDF2 = PBKDF2{Iterations: 210000, SaltLen: 16, KeyLen: 64}
+
+// pbkdf2MaxIterations limits the iterations of parsed hashes.
+const pbkdf2MaxIterations = 100000000
+
+// ID returns "pbkdf2-sha512".
+func (p PBKDF2) ID() string {
+	return "pbkdf2-sha512"
+}
+
+// Hash returns the PHC string of the password with a new random salt.
+func (p PBKDF2) Hash(password string) (string, error) {
+	if p.Iterations < 1 || p.SaltLen < 1 || p.KeyLen < 1 {
+		return "", fmt.Errorf("invalid pbkdf2 parameters %+v", p)
+	}
+	salt := make([]byte, p.SaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA512([]byte(password), salt, p.Iterations, p.KeyLen)
+	return fmt.Sprintf("$%s$i=%d$%s$%s", p.ID(), p.Iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the PHC string using the
+// parameters of the string.
+func (p PBKDF2) Verify(password, hash string) (bool, error) {
+	iterations, salt, key, err := p.parse(hash)
+	if err != nil {
+		return false, err
+	}
+	actual := pbkdf2SHA512([]byte(password), salt, iterations, len(key))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// NeedsRehash reports whether the PHC string is malformed or was created
+// with other parameters.
+func (p PBKDF2) NeedsRehash(hash string) bool {
+	iterations, salt, key, err := p.parse(hash)
+	return err != nil || iterations != p.Iterations ||
+		len(salt) != p.SaltLen || len(key) != p.KeyLen
+}
+
+func (p PBKDF2) parse(hash string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != p.ID() ||
+		!strings.HasPrefix(parts[2], "i=") {
+		return 0, nil, nil, errors.New("malformed pbkdf2 password hash")
+	}
+	iterations, err = strconv.Atoi(parts[2][len("i="):])
+	if err != nil || iterations < 1 || iterations > pbkdf2MaxIterations {
+		return 0, nil, nil, errors.New("malformed pbkdf2 password hash iterations")
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(salt) == 0 {
+		return 0, nil, nil, errors.New("malformed pbkdf2 password hash salt")
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, errors.New("malformed pbkdf2 password hash")
+	}
+	return iterations, salt, key, nil
+}
+
+// pbkdf2SHA512 derives a key of keyLen bytes from the password and salt with
+// PBKDF2 (RFC 8018, section 5.2) using HMAC-SHA-512.
+func pbkdf2SHA512(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha512.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		// U_1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		// T = U_1 ^ U_2 ^ ... ^ U_c
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
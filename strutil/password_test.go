@@ -0,0 +1,165 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPBKDF2SHA512(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 64, "867f70cf1ade02cff3752599a3a53dc4af34c7a669815ae5d513554e1c8cf252" +
+			"c02d470a285a0501bad999bfe943c08f050235d7d68b1da55e63f73b60a57fce"},
+		{"password", "salt", 2, 64, "e1d9c16aa681708a45f5c7c4e215ceb66e011a2e9f0040713f18aefdb866d53c" +
+			"f76cab2868a39b9f7840edce4fef5a82be67335c77a6068e04112754f27ccf4e"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 64,
+			"8c0511f4c6e597c6ac6315d8f0362e225f3c501495ba23b868c005174dc4ee71" +
+				"115b59f9e60cd9532fa33e0f75aefe30225c583a186cd82bd4daea9724a3d3b8"},
+		// multiple blocks
+		{"password", "salt", 3, 100, "b6b07cb2cebf4ad84468391a543824fccffe0e0769dbe6bddf10a65673c4b648" +
+			"e612d44918f9ce9a19a1294cf5140628084ba994c3b21a4ef4741220b811c633" +
+			"cfc0641fccbcc4164f1bbfcb1f33f595ae9aa4a33ddcce570157775980362c0e" +
+			"e28aa340"},
+	}
+	for _, tt := range tests {
+		got := pbkdf2SHA512([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen)
+		assert.Equal(t, tt.want, hex.EncodeToString(got))
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	password := " My dark, little Secret 🔐 "
+
+	hash, err := HashPassword(password)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$pbkdf2-sha512$i=210000$"))
+	assert.False(t, NeedsRehash(hash))
+
+	ok, err := VerifyPassword(password, hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyPassword(password+"x", hash)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// new salt per hash
+	hash2, err := HashPassword(password)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, hash2)
+
+	// changed parameters
+	cheap := PBKDF2{Iterations: 1000, SaltLen: 16, KeyLen: 32}
+	cheapHash, err := cheap.Hash(password)
+	assert.Nil(t, err)
+	assert.True(t, NeedsRehash(cheapHash))
+	ok, err = VerifyPassword(password, cheapHash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// unknown and malformed hashes
+	_, err = VerifyPassword(password, "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA")
+	assert.True(t, errors.Is(err, ErrUnknownPasswordHasher))
+	_, err = VerifyPassword(password, Hash(password, "not a password hash"))
+	assert.True(t, errors.Is(err, ErrUnknownPasswordHasher))
+	for _, malformed := range []string{
+		"$pbkdf2-sha512$i=1000$c2FsdA",
+		"$pbkdf2-sha512$n=1000$c2FsdA$aGFzaA",
+		"$pbkdf2-sha512$i=0$c2FsdA$aGFzaA",
+		"$pbkdf2-sha512$i=x$c2FsdA$aGFzaA",
+		"$pbkdf2-sha512$i=1000$!$aGFzaA",
+		"$pbkdf2-sha512$i=1000$c2FsdA$",
+	} {
+		_, err = VerifyPassword(password, malformed)
+		assert.NotNil(t, err, malformed)
+		assert.True(t, NeedsRehash(malformed), malformed)
+	}
+
+	_, err = PBKDF2{}.Hash(password)
+	assert.NotNil(t, err)
+}
+
+// plainHasher is an insecure PasswordHasher for testing.
+type plainHasher struct{}
+
+func (plainHasher) ID() string { return "plain" }
+
+func (plainHasher) Hash(password string) (string, error) { return "$plain$" + password, nil }
+
+func (plainHasher) Verify(password, hash string) (bool, error) {
+	return hash == "$plain$"+password, nil
+}
+
+func (plainHasher) NeedsRehash(hash string) bool { return false }
+
+func TestSetPasswordHasher(t *testing.T) {
+	defer SetPasswordHasher(DefaultPBKDF2)
+
+	pbkdf2Hash, err := HashPassword("secret")
+	assert.Nil(t, err)
+
+	SetPasswordHasher(plainHasher{})
+	hash, err := HashPassword("secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "$plain$secret", hash)
+	assert.False(t, NeedsRehash(hash))
+
+	// hashes of other algorithms are still verified
+	assert.True(t, NeedsRehash(pbkdf2Hash))
+	ok, err := VerifyPassword("secret", pbkdf2Hash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+//////////////////////
+// Benchmarks
+//////////////////////
+
+// BenchmarkHashPassword measures the cost of PBKDF2 iterations, the duration
+// of a hash should be tuned to what the servers can afford per login.
+func BenchmarkHashPassword(b *testing.B) {
+	for _, iterations := range []int{100000, 210000, 600000} {
+		p := PBKDF2{Iterations: iterations, SaltLen: 16, KeyLen: 64}
+		b.Run(fmt.Sprintf("pbkdf2-sha512 i=%d", iterations), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Hash("My dark, little secret"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkVerifyPassword(b *testing.B) {
+	b.ReportAllocs()
+	hash, _ := HashPassword("My dark, little secret")
+
+	for i := 0; i < b.N; i++ {
+		if ok, err := VerifyPassword("My dark, little secret", hash); !ok || err != nil {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -16,11 +16,71 @@ package strutil
 
 import (
 	crand "crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	mrand "math/rand"
 	"time"
+	"unicode/utf8"
 )
 
+// Alphabet is a set of unique characters random strings are generated from,
+// e.g. Alphabet("ACGT") or one of the presets.
+type Alphabet string
+
+// Alphabet presets.
+const (
+	AlphabetAlphaNumeric Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"   // A-Z a-z 0-9
+	AlphabetAlpha        Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"             // A-Z a-z
+	AlphabetNumber       Alphabet = "0123456789"                                                       // 0-9
+	AlphabetPIN          Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZ"                                // 1-9 A-Z without O, I
+	AlphabetHex          Alphabet = "0123456789abcdef"                                                 // 0-9 a-f
+	AlphabetBase64URL    Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_" // A-Z a-z 0-9 - _
+)
+
+// alphabetMaxLen is the maximum number of characters of an alphabet.
+const alphabetMaxLen = 1 << 16
+
+// Entropy returns the entropy of a random string of n characters of the
+// alphabet in bits.
+func (a Alphabet) Entropy(n int) float64 {
+	return float64(n) * math.Log2(float64(utf8.RuneCountInString(string(a))))
+}
+
+// LengthFor returns the number of characters a random string of the
+// alphabet needs for at least the entropy in bits, e.g. 22 characters of
+// AlphabetAlphaNumeric for 128 bit.
+func (a Alphabet) LengthFor(bits int) int {
+	perChar := math.Log2(float64(utf8.RuneCountInString(string(a))))
+	n := int(math.Ceil(float64(bits) / perChar))
+	// correct floating point errors, e.g. 128 bits of AlphabetHex
+	for n > 0 && a.Entropy(n-1) >= float64(bits) {
+		n--
+	}
+	return n
+}
+
+// validate returns the characters of the alphabet or an error if it has less
+// than 2 or duplicate characters.
+func (a Alphabet) validate() ([]rune, error) {
+	if !utf8.ValidString(string(a)) {
+		return nil, errors.New("alphabet is not valid UTF-8")
+	}
+	chars := []rune(string(a))
+	if len(chars) < 2 || len(chars) > alphabetMaxLen {
+		return nil, fmt.Errorf("alphabet needs 2 to %d characters, has %d", alphabetMaxLen, len(chars))
+	}
+	seen := make(map[rune]bool, len(chars))
+	for _, c := range chars {
+		if seen[c] {
+			return nil, fmt.Errorf("alphabet has duplicate character '%c'", c)
+		}
+		seen[c] = true
+	}
+	return chars, nil
+}
+
 // Random generates a NOT SECURE! random string of defined length
 // The result is NOT SECURE but fast because it uses the time as seed
 // taken from https://goo.gl/9GBmNN
@@ -49,52 +109,90 @@ func Random(n int) string {
 	return string(b)
 }
 
+// RandomString returns a SECURELY generated random string of n characters of
+// the alphabet. Every character is equally likely as random values which
+// do not map evenly to the alphabet are rejected and drawn again.
+// It returns an error for invalid alphabets.
+func RandomString(n int, alphabet Alphabet) (string, error) {
+	return randomString(crand.Reader, n, alphabet)
+}
+
+// RandomStringWithEntropy returns a SECURELY generated random string of the
+// alphabet having at least the entropy in bits, e.g. 128 for session IDs.
+func RandomStringWithEntropy(bits int, alphabet Alphabet) (string, error) {
+	if _, err := alphabet.validate(); err != nil {
+		return "", err
+	}
+	return RandomString(alphabet.LengthFor(bits), alphabet)
+}
+
 // RandomSecure returns a SECURELY generated random string
 // randType can be:
-//	- "alpha" for range A-Z a-z
-//	- "number" for range 0-9, returns string number with leading 0s
-//	- "pin" for range 1-9 A-Z without O, I letters
-//	- any other value for A-Z a-z 0-9
+//   - "alpha" for range A-Z a-z (AlphabetAlpha)
+//   - "number" for range 0-9, returns string number with leading 0s (AlphabetNumber)
+//   - "pin" for range 1-9 A-Z without O, I letters (AlphabetPIN)
+//   - any other value for A-Z a-z 0-9 (AlphabetAlphaNumeric)
+//
+// Use RandomString for other alphabets.
 // It will panic in the super rare case of an issue
 // to avoid any cascading security issues
-// orients on https://goo.gl/kK987i and https://goo.gl/NRrS7y
 func RandomSecure(strSize int, randType string) string {
-	dict := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-
-	if randType == "alpha" {
-		dict = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	} else if randType == "number" {
-		dict = "0123456789"
-	} else if randType == "pin" {
-		dict = "123456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	alphabet := AlphabetAlphaNumeric
+	switch randType {
+	case "alpha":
+		alphabet = AlphabetAlpha
+	case "number":
+		alphabet = AlphabetNumber
+	case "pin":
+		alphabet = AlphabetPIN
 	}
 
-	var b = make([]byte, strSize)
-	_, err := crand.Read(b)
+	s, err := RandomString(strSize, alphabet)
 	if err != nil {
 		msg := "crypto/rand is unavailable: strutil.RandomSecure() "
 		msg += "failed with %#v"
 		panic(fmt.Sprintf(msg, err))
 	}
+	return s
+}
 
-	// convert the bytes into the appropriate set of chars
-	for k, v := range b {
-		b[k] = dict[v%byte(len(dict))]
+// randomString returns a random string of n characters of the alphabet
+// reading random values from r using rejection sampling.
+func randomString(r io.Reader, n int, alphabet Alphabet) (string, error) {
+	chars, err := alphabet.validate()
+	if err != nil {
+		return "", err
 	}
-
-	rndStr1 := string(b)
-
-	if randType == "number" || randType == "pin" || strSize > 64 {
-		return rndStr1
+	if n < 0 {
+		return "", errors.New("random string length must not be negative")
 	}
 
-	// if alphanumeric then make it really, really random
-	// by taking the current time as seed for a hash
-	timeStr := fmt.Sprintf("%d", time.Now().UnixNano())
-	rndStr2 := timeStr + rndStr1
-	hash := sha256Hash(rndStr2) // 64 chars
+	// the smallest mask covering all indexes, so at least half of the
+	// random values are accepted
+	mask := 1
+	for mask < len(chars)-1 {
+		mask = mask<<1 | 1
+	}
+	valueSize := 1
+	if mask > math.MaxUint8 {
+		valueSize = 2
+	}
 
-	// take the hash from the end
-	secret := hash[len(hash)-strSize:]
-	return secret
+	result := make([]rune, 0, n)
+	buf := make([]byte, valueSize*(n+n/2+8))
+	for len(result) < n {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		for i := 0; i+valueSize <= len(buf) && len(result) < n; i += valueSize {
+			v := int(buf[i])
+			if valueSize == 2 {
+				v = v<<8 | int(buf[i+1])
+			}
+			if idx := v & mask; idx < len(chars) {
+				result = append(result, chars[idx])
+			}
+		}
+	}
+	return string(result), nil
 }
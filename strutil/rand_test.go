@@ -15,8 +15,11 @@
 package strutil
 
 import (
+	"bytes"
 	"strconv"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -69,3 +72,98 @@ func TestRandomSecure(t *testing.T) {
 	// should not contain 0 zero
 	assert.NotContains(t, pin, "0")
 }
+
+func TestRandomString(t *testing.T) {
+	for _, alphabet := range []Alphabet{
+		AlphabetAlphaNumeric, AlphabetAlpha, AlphabetNumber,
+		AlphabetPIN, AlphabetHex, AlphabetBase64URL, "ACGT", "äöü€",
+	} {
+		s, err := RandomString(200, alphabet)
+		assert.Nil(t, err, alphabet)
+		assert.Equal(t, 200, utf8.RuneCountInString(s), alphabet)
+		for _, c := range s {
+			assert.True(t, strings.ContainsRune(string(alphabet), c), alphabet)
+		}
+	}
+
+	// all characters are used, RandomSecure was hex only for alpha-numeric
+	s, err := RandomString(2000, AlphabetAlphaNumeric)
+	assert.Nil(t, err)
+	for _, c := range AlphabetAlphaNumeric {
+		assert.True(t, strings.ContainsRune(s, c), string(c))
+	}
+	assert.Contains(t, RandomSecure(2000, ""), "Z")
+
+	s, err = RandomString(0, AlphabetHex)
+	assert.Nil(t, err)
+	assert.Empty(t, s)
+
+	for _, invalid := range []Alphabet{"", "a", "abca", "ab\xff"} {
+		_, err := RandomString(10, invalid)
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = RandomString(-1, AlphabetHex)
+	assert.NotNil(t, err)
+}
+
+func TestRandomStringRejectionSampling(t *testing.T) {
+	// values above the alphabet are rejected instead of wrapped around,
+	// random values are read in batches
+	padding := make([]byte, 64)
+	r := bytes.NewReader(append([]byte{0x03, 0x01, 0xff, 0x02, 0x00}, padding...))
+	s, err := randomString(r, 3, "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, "bca", s)
+
+	// alphabets of more than 256 characters use 2 bytes per value
+	chars := make([]rune, 300)
+	for i := range chars {
+		chars[i] = rune(0x100 + i)
+	}
+	r = bytes.NewReader(append([]byte{0x01, 0x2c, 0x01, 0x2b}, padding...))
+	s, err = randomString(r, 2, Alphabet(chars))
+	assert.Nil(t, err)
+	assert.Equal(t, string(chars[299])+string(chars[0]), s)
+
+	// read errors are returned
+	_, err = randomString(bytes.NewReader(nil), 3, "abc")
+	assert.NotNil(t, err)
+}
+
+func TestAlphabetEntropy(t *testing.T) {
+	assert.Equal(t, 128.0, AlphabetHex.Entropy(32))
+	assert.Equal(t, 32, AlphabetHex.LengthFor(128))
+	assert.Equal(t, 22, AlphabetAlphaNumeric.LengthFor(128))
+	assert.Equal(t, 22, AlphabetBase64URL.LengthFor(128))
+	assert.Equal(t, 39, AlphabetNumber.LengthFor(128))
+	assert.True(t, AlphabetAlphaNumeric.Entropy(22) >= 128)
+	assert.True(t, AlphabetAlphaNumeric.Entropy(21) < 128)
+
+	s, err := RandomStringWithEntropy(128, AlphabetAlphaNumeric)
+	assert.Nil(t, err)
+	assert.Len(t, s, 22)
+	_, err = RandomStringWithEntropy(128, "a")
+	assert.NotNil(t, err)
+}
+
+//////////////////////
+// Benchmarks
+//////////////////////
+
+func BenchmarkRandomString(b *testing.B) {
+	for name, alphabet := range map[string]Alphabet{
+		"alpha-numeric": AlphabetAlphaNumeric,
+		"number":        AlphabetNumber,
+		"hex":           AlphabetHex,
+	} {
+		alphabet := alphabet
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := RandomString(32, alphabet); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
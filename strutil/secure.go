@@ -17,23 +17,74 @@ package strutil
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 )
 
+// Encoding is the encoding of ciphertexts returned by EncryptWithAD and
+// EncryptDeterministic.
+type Encoding int
+
+// Encodings of ciphertexts.
+const (
+	EncodingHex       Encoding = iota // Hex, as returned by Encrypt
+	EncodingBase64URL                 // Unpadded base64url, a third shorter than hex
+	EncodingRaw                       // Unencoded bytes, e.g. for binary columns
+)
+
+func (e Encoding) encode(b []byte) (string, error) {
+	switch e {
+	case EncodingHex:
+		return hex.EncodeToString(b), nil
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	case EncodingRaw:
+		return string(b), nil
+	}
+	return "", fmt.Errorf("unknown ciphertext encoding %d", e)
+}
+
+func (e Encoding) decode(s string) ([]byte, error) {
+	switch e {
+	case EncodingHex:
+		return hex.DecodeString(s)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(s)
+	case EncodingRaw:
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown ciphertext encoding %d", e)
+}
+
 // Encrypt encrypts data using 256-bit AES-GCM.
-// Needs a 256 bit key (32 characters) or it fails
+// Needs a 256 bit key (32 characters), 128 and 192 bit keys are accepted for
+// compatibility, it fails with *InvalidKeyError for other keys,
+// see DeriveKey and NewPassphraseKey for deriving keys
 // Returned string is empty on error
 func Encrypt(text string, key string) (string, error) {
-	plaintext := []byte(text)
-	block, err := aes.NewCipher([]byte(key))
-	if err != nil {
-		return "", err
-	}
+	return EncryptWithAD(text, key, nil, EncodingHex)
+}
+
+// Decrypt decrypts data using 256-bit AES-GCM.
+// Returned string is empty on error
+func Decrypt(encryptedText string, key string) (string, error) {
+	return DecryptWithAD(encryptedText, key, nil, EncodingHex)
+}
 
-	gcm, err := cipher.NewGCM(block)
+// EncryptWithAD encrypts data using 256-bit AES-GCM like Encrypt and returns
+// it in the encoding. The additional data, e.g. table, column and row ID, is
+// authenticated but not stored, so the ciphertext can only be decrypted with
+// the same additional data and not be copied to another row unnoticed.
+// Returned string is empty on error
+func EncryptWithAD(text, key string, additionalData []byte, encoding Encoding) (string, error) {
+	gcm, err := newGCM([]byte(key))
 	if err != nil {
 		return "", err
 	}
@@ -43,41 +94,104 @@ func Encrypt(text string, key string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	seal := gcm.Seal(nonce, nonce, plaintext, nil)
-	//return string(seal[:]), nil
-	return hex.EncodeToString(seal), nil
+	seal := gcm.Seal(nonce, nonce, []byte(text), additionalData)
+	return encoding.encode(seal)
 }
 
-// Decrypt decrypts data using 256-bit AES-GCM.
+// DecryptWithAD decrypts data of EncryptWithAD having the same additional
+// data and encoding.
 // Returned string is empty on error
-func Decrypt(encryptedText string, key string) (string, error) {
-	ciphertext, err := hex.DecodeString(encryptedText)
+func DecryptWithAD(encryptedText, key string, additionalData []byte, encoding Encoding) (string, error) {
+	ciphertext, err := encoding.decode(encryptedText)
 	if err != nil {
 		return "", err
 	}
-	//ciphertext := []byte(hexText)
-	//ciphertext := []byte(encryptedText)
-	block, err := aes.NewCipher([]byte(key))
+	gcm, err := newGCM([]byte(key))
 	if err != nil {
 		return "", err
 	}
+	return open(gcm, ciphertext, additionalData)
+}
 
-	gcm, err := cipher.NewGCM(block)
+// EncryptDeterministic encrypts data deterministically using AES-256-CTR
+// with a synthetic IV, the truncated HMAC-SHA-256 of additional data and
+// text (SIV construction), and returns it in the encoding. The same text,
+// key and additional data always result in the same ciphertext, so it can
+// be used for unique constraints and lookups, but it reveals which
+// ciphertexts are equal. Use EncryptWithAD wherever that is not needed.
+// Needs a 256 bit key (32 characters) or it fails with *InvalidKeyError
+// Returned string is empty on error
+func EncryptDeterministic(text, key string, additionalData []byte, encoding Encoding) (string, error) {
+	encKey, macKey, err := deterministicKeys(key)
 	if err != nil {
 		return "", err
 	}
+	iv := syntheticIV(macKey, additionalData, []byte(text))
+	ciphertext, err := aesCTR(encKey, iv, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	return encoding.encode(append(iv, ciphertext...))
+}
 
-	if len(ciphertext) < gcm.NonceSize() {
+// DecryptDeterministic decrypts data of EncryptDeterministic having the same
+// additional data and encoding.
+// Returned string is empty on error
+func DecryptDeterministic(encryptedText, key string, additionalData []byte, encoding Encoding) (string, error) {
+	ciphertext, err := encoding.decode(encryptedText)
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) < aes.BlockSize {
 		return "", errors.New("malformed ciphertext")
 	}
-
-	decr, err := gcm.Open(nil,
-		ciphertext[:gcm.NonceSize()],
-		ciphertext[gcm.NonceSize():],
-		nil,
-	)
+	encKey, macKey, err := deterministicKeys(key)
+	if err != nil {
+		return "", err
+	}
+	iv := ciphertext[:aes.BlockSize]
+	text, err := aesCTR(encKey, iv, ciphertext[aes.BlockSize:])
 	if err != nil {
 		return "", err
 	}
-	return string(decr[:]), nil
+	// constant time comparison
+	if !hmac.Equal(iv, syntheticIV(macKey, additionalData, text)) {
+		return "", errors.New("message authentication failed")
+	}
+	return string(text), nil
+}
+
+// deterministicKeys derives independent encryption and MAC keys from the key.
+func deterministicKeys(key string) (encKey, macKey []byte, err error) {
+	if err := checkEncryptionKey([]byte(key)); err != nil {
+		return nil, nil, err
+	}
+	derive := func(label string) []byte {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write([]byte(label))
+		return h.Sum(nil)
+	}
+	return derive("strutil-deterministic-enc"), derive("strutil-deterministic-mac"), nil
+}
+
+// syntheticIV returns the truncated HMAC-SHA-256 of additional data and text.
+func syntheticIV(macKey, additionalData, text []byte) []byte {
+	h := hmac.New(sha256.New, macKey)
+	// the length prefix separates additional data and text unambiguously
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(additionalData)))
+	h.Write(n[:])
+	h.Write(additionalData)
+	h.Write(text)
+	return h.Sum(nil)[:aes.BlockSize]
+}
+
+func aesCTR(key, iv, text []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(text))
+	cipher.NewCTR(block, iv).XORKeyStream(out, text)
+	return out, nil
 }
@@ -46,6 +46,84 @@ func TestEncryptDecrypt(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestEncryptWithAD(t *testing.T) {
+	text := " My dark, little Secret 🔐 "
+	password := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	ad := []byte("users.email:42")
+
+	for _, encoding := range []Encoding{EncodingHex, EncodingBase64URL, EncodingRaw} {
+		encr, err := EncryptWithAD(text, password, ad, encoding)
+		assert.Nil(t, err, encoding)
+		decr, err := DecryptWithAD(encr, password, ad, encoding)
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, text, decr, encoding)
+
+		// other row
+		decr, err = DecryptWithAD(encr, password, []byte("users.email:43"), encoding)
+		assert.NotNil(t, err, encoding)
+		assert.Empty(t, decr, encoding)
+	}
+
+	// compatible with Encrypt without additional data
+	encr, err := Encrypt(text, password)
+	assert.Nil(t, err)
+	decr, err := DecryptWithAD(encr, password, nil, EncodingHex)
+	assert.Nil(t, err)
+	assert.Equal(t, text, decr)
+
+	// base64url is shorter than hex
+	b64, err := EncryptWithAD(text, password, nil, EncodingBase64URL)
+	assert.Nil(t, err)
+	assert.Less(t, len(b64), len(encr))
+
+	_, err = EncryptWithAD(text, password, nil, Encoding(99))
+	assert.NotNil(t, err)
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	text := " My dark, little Secret 🔐 "
+	password := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	wrongPW := "8JtsDUxzjp372XxNKypHB7zQbnjUBBoG"
+	ad := []byte("users.email")
+
+	for _, encoding := range []Encoding{EncodingHex, EncodingBase64URL, EncodingRaw} {
+		encr, err := EncryptDeterministic(text, password, ad, encoding)
+		assert.Nil(t, err, encoding)
+		encr2, err := EncryptDeterministic(text, password, ad, encoding)
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, encr, encr2, encoding)
+
+		decr, err := DecryptDeterministic(encr, password, ad, encoding)
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, text, decr, encoding)
+
+		_, err = DecryptDeterministic(encr, password, []byte("users.name"), encoding)
+		assert.NotNil(t, err, encoding)
+		_, err = DecryptDeterministic(encr, wrongPW, ad, encoding)
+		assert.NotNil(t, err, encoding)
+	}
+
+	// different texts, additional data and keys
+	encr, _ := EncryptDeterministic(text, password, ad, EncodingHex)
+	other, _ := EncryptDeterministic(text+"x", password, ad, EncodingHex)
+	assert.NotEqual(t, encr, other)
+	other, _ = EncryptDeterministic(text, password, []byte("users.name"), EncodingHex)
+	assert.NotEqual(t, encr, other)
+	other, _ = EncryptDeterministic(text, wrongPW, ad, EncodingHex)
+	assert.NotEqual(t, encr, other)
+
+	// modified ciphertext
+	modified := []byte(encr)
+	modified[len(modified)-1] ^= 1
+	_, err := DecryptDeterministic(string(modified), password, ad, EncodingHex)
+	assert.NotNil(t, err)
+	_, err = DecryptDeterministic("00", password, ad, EncodingHex)
+	assert.NotNil(t, err)
+
+	_, err = EncryptDeterministic(text, password[:16], ad, EncodingHex)
+	assert.NotNil(t, err)
+}
+
 //////////////////////
 // Benchmarks
 //////////////////////
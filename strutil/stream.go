@@ -0,0 +1,429 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// StreamSegmentSize is the size of the plaintext segments of encrypted
+// streams in bytes.
+const StreamSegmentSize = 64 * 1024
+
+// ErrStreamTruncated is returned when reading an encrypted stream which ends
+// before its last segment, e.g. a partially written file.
+var ErrStreamTruncated = errors.New("encrypted stream is truncated")
+
+// Encrypted streams consist of a header and segments of StreamSegmentSize
+// bytes of plaintext, each encrypted using 256-bit AES-GCM. The header is
+//
+//	version (1 byte) || key ID length (1 byte) || key ID ||
+//	segment size (4 bytes) || salt (32 bytes) || nonce prefix (7 bytes)
+//
+// Every stream is encrypted with its own key HMAC-SHA-256(key, salt), which
+// is 256 bit for all key sizes accepted by Encrypt. The nonce of a segment is
+// the nonce prefix, the segment counter (4 bytes) and a byte which is 1 for
+// the last segment and 0 for all others, so segments can not be reordered,
+// dropped or truncated unnoticed. The header is authenticated as additional
+// data of every segment.
+const (
+	streamVersion         = 1
+	streamSaltSize        = 32
+	streamNoncePrefixSize = 7
+	streamMaxSegmentSize  = 16 * 1024 * 1024
+)
+
+// streamHeader is the header of an encrypted stream.
+type streamHeader struct {
+	keyID       string
+	segmentSize int
+	salt        []byte
+	noncePrefix []byte
+	raw         []byte
+}
+
+func newStreamHeader(keyID string) (*streamHeader, error) {
+	h := &streamHeader{keyID: keyID, segmentSize: StreamSegmentSize}
+	random := make([]byte, streamSaltSize+streamNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	h.salt, h.noncePrefix = random[:streamSaltSize], random[streamSaltSize:]
+
+	h.raw = append([]byte{streamVersion, byte(len(keyID))}, keyID...)
+	h.raw = append(h.raw, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(h.raw[len(h.raw)-4:], uint32(h.segmentSize))
+	h.raw = append(h.raw, random...)
+	return h, nil
+}
+
+func readStreamHeader(r io.Reader) (*streamHeader, error) {
+	start := make([]byte, 2)
+	if _, err := io.ReadFull(r, start); err != nil {
+		return nil, fmt.Errorf("reading encrypted stream header: %w", err)
+	}
+	if start[0] != streamVersion {
+		return nil, fmt.Errorf("unsupported encrypted stream version %d", start[0])
+	}
+	rest := make([]byte, int(start[1])+4+streamSaltSize+streamNoncePrefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("reading encrypted stream header: %w", err)
+	}
+
+	keyIDLen := int(start[1])
+	h := &streamHeader{
+		keyID:       string(rest[:keyIDLen]),
+		segmentSize: int(binary.BigEndian.Uint32(rest[keyIDLen:])),
+		salt:        rest[keyIDLen+4 : keyIDLen+4+streamSaltSize],
+		noncePrefix: rest[keyIDLen+4+streamSaltSize:],
+		raw:         append(start, rest...),
+	}
+	if h.segmentSize < 1 || h.segmentSize > streamMaxSegmentSize {
+		return nil, fmt.Errorf("invalid encrypted stream segment size %d", h.segmentSize)
+	}
+	return h, nil
+}
+
+// aead returns AES-GCM with the stream key derived from the key.
+func (h *streamHeader) aead(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(h.salt)
+	return newGCM(mac.Sum(nil))
+}
+
+// nonce returns the nonce of the segment.
+func (h *streamHeader) nonce(counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, h.noncePrefix...)
+	nonce = append(nonce, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it
+// using 256-bit AES-GCM in segments and writing it to w. Close must be
+// called to write the last segment, it does not close w.
+// Like Encrypt it needs a 128, 192 or 256 bit key (16, 24 or 32 characters)
+// or it fails with *InvalidKeyError.
+func NewEncryptWriter(w io.Writer, key string) (io.WriteCloser, error) {
+	return newEncryptWriter(w, "", []byte(key))
+}
+
+// NewDecryptReader returns a reader decrypting the stream of
+// NewEncryptWriter read from r. Read returns an error if a segment was
+// modified and ErrStreamTruncated if the stream ends early.
+func NewDecryptReader(r io.Reader, key string) (io.Reader, error) {
+	h, err := readStreamHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, h, []byte(key))
+}
+
+// EncryptStream encrypts everything read from src using 256-bit AES-GCM in
+// segments and writes it to dst, see NewEncryptWriter.
+func EncryptStream(dst io.Writer, src io.Reader, key string) error {
+	w, err := NewEncryptWriter(dst, key)
+	if err != nil {
+		return err
+	}
+	return copyAndClose(w, src)
+}
+
+// DecryptStream decrypts the stream of EncryptStream read from src and
+// writes it to dst. As dst is written before the stream is verified
+// completely, its content must be discarded if an error is returned.
+func DecryptStream(dst io.Writer, src io.Reader, key string) error {
+	r, err := NewDecryptReader(src, key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it
+// with the primary key like strutil.NewEncryptWriter. The key ID is written
+// to the stream header.
+func (k *Keyring) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	return newEncryptWriter(w, k.keys[0].id, k.keys[0].key)
+}
+
+// NewDecryptReader returns a reader decrypting the stream of
+// Keyring.NewEncryptWriter with the key named in its header. It returns
+// ErrUnknownKeyID if the key is not in the keyring.
+func (k *Keyring) NewDecryptReader(r io.Reader) (io.Reader, error) {
+	h, err := readStreamHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.key(h.keyID)
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownKeyID, h.keyID)
+	}
+	return newDecryptReader(r, h, key.key)
+}
+
+// EncryptStream encrypts everything read from src with the primary key and
+// writes it to dst, see Keyring.NewEncryptWriter.
+func (k *Keyring) EncryptStream(dst io.Writer, src io.Reader) error {
+	w, err := k.NewEncryptWriter(dst)
+	if err != nil {
+		return err
+	}
+	return copyAndClose(w, src)
+}
+
+// DecryptStream decrypts the stream of Keyring.EncryptStream read from src
+// and writes it to dst. Its content must be discarded if an error is
+// returned.
+func (k *Keyring) DecryptStream(dst io.Writer, src io.Reader) error {
+	r, err := k.NewDecryptReader(src)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// EncryptFile encrypts the file at srcPath with EncryptStream into a new
+// file at dstPath, which is only readable by the owner.
+func EncryptFile(dstPath, srcPath, key string) error {
+	return transformFile(dstPath, srcPath, func(dst io.Writer, src io.Reader) error {
+		return EncryptStream(dst, src, key)
+	})
+}
+
+// DecryptFile decrypts the file of EncryptFile at srcPath into a new file at
+// dstPath, which is only readable by the owner. The file at dstPath is
+// removed if the decryption fails.
+func DecryptFile(dstPath, srcPath, key string) error {
+	return transformFile(dstPath, srcPath, func(dst io.Writer, src io.Reader) error {
+		return DecryptStream(dst, src, key)
+	})
+}
+
+// transformFile writes the transformed file at srcPath to a new file at
+// dstPath, which is removed on errors.
+func transformFile(dstPath, srcPath string, transform func(dst io.Writer, src io.Reader) error) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = transform(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dstPath)
+		return err
+	}
+	return nil
+}
+
+func copyAndClose(w io.WriteCloser, src io.Reader) error {
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// encryptWriter encrypts the written data in segments.
+type encryptWriter struct {
+	w       io.Writer
+	header  *streamHeader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint32
+	err     error
+}
+
+func newEncryptWriter(w io.Writer, keyID string, key []byte) (*encryptWriter, error) {
+	if len(keyID) > maxKeyIDLen {
+		return nil, fmt.Errorf("encryption key ID is longer than %d bytes", maxKeyIDLen)
+	}
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	h, err := newStreamHeader(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := h.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(h.raw); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		header: h,
+		aead:   aead,
+		buf:    make([]byte, 0, h.segmentSize+aead.Overhead()),
+	}, nil
+}
+
+// Write encrypts p. A full segment is only written once more data follows,
+// as it is unknown before whether it is the last one.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == e.header.segmentSize {
+			if e.err = e.writeSegment(false); e.err != nil {
+				return written, e.err
+			}
+		}
+		n := copy(e.buf[len(e.buf):e.header.segmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last segment. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.writeSegment(true)
+	if e.err != nil {
+		return e.err
+	}
+	e.err = errors.New("encrypt writer is closed")
+	return nil
+}
+
+func (e *encryptWriter) writeSegment(last bool) error {
+	if e.counter == math.MaxUint32 {
+		return errors.New("encrypted stream is too long")
+	}
+	seal := e.aead.Seal(e.buf[:0], e.header.nonce(e.counter, last), e.buf, e.header.raw)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(seal)
+	return err
+}
+
+// decryptReader decrypts the segments read from r.
+type decryptReader struct {
+	r       io.Reader
+	header  *streamHeader
+	aead    cipher.AEAD
+	buf     []byte
+	pending []byte
+	plain   []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+func newDecryptReader(r io.Reader, h *streamHeader, key []byte) (*decryptReader, error) {
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	aead, err := h.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	// one more byte than a segment for detecting whether it is the last
+	return &decryptReader{
+		r:      r,
+		header: h,
+		aead:   aead,
+		buf:    make([]byte, h.segmentSize+aead.Overhead()+1),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.readSegment()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readSegment() error {
+	segmentLen := d.header.segmentSize + d.aead.Overhead()
+
+	n := copy(d.buf, d.pending)
+	m, err := io.ReadFull(d.r, d.buf[n:])
+	n += m
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+	if n < d.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+
+	segment := d.buf[:n]
+	if !last {
+		segment = d.buf[:segmentLen]
+		d.pending = append(d.pending[:0], d.buf[segmentLen:n]...)
+	}
+	// the last segment is not decrypted in place as Open overwrites it on
+	// errors, so it can be checked for truncation afterwards
+	dst := segment[:0]
+	if last {
+		dst = nil
+	}
+	plain, err := d.aead.Open(dst, d.header.nonce(d.counter, last), segment, d.header.raw)
+	if err != nil {
+		if last {
+			// a segment which is not the last one decrypts with last false
+			if _, err := d.aead.Open(nil, d.header.nonce(d.counter, false), segment, d.header.raw); err == nil {
+				return ErrStreamTruncated
+			}
+		}
+		return fmt.Errorf("decrypting stream segment %d: %w", d.counter, err)
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptStream(t *testing.T) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+
+	for _, size := range []int{0, 1, StreamSegmentSize - 1, StreamSegmentSize, StreamSegmentSize + 1, 3*StreamSegmentSize + 17} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		assert.Nil(t, err)
+
+		var encr bytes.Buffer
+		assert.Nil(t, EncryptStream(&encr, bytes.NewReader(plain), key), size)
+
+		var decr bytes.Buffer
+		assert.Nil(t, DecryptStream(&decr, bytes.NewReader(encr.Bytes()), key), size)
+		assert.Equal(t, plain, decr.Bytes(), size)
+
+		err = DecryptStream(ioutil.Discard, bytes.NewReader(encr.Bytes()), "8JtsDUxzjp372XxNKypHB7zQbnjUBBoG")
+		assert.NotNil(t, err, size)
+	}
+
+	_, err := NewEncryptWriter(ioutil.Discard, "too short")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptWriterSmallWrites(t *testing.T) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	plain := bytes.Repeat([]byte("My dark, little Secret 🔐 "), 5000)
+
+	var encr bytes.Buffer
+	w, err := NewEncryptWriter(&encr, key)
+	assert.Nil(t, err)
+	for i := 0; i < len(plain); i += 7 {
+		end := i + 7
+		if end > len(plain) {
+			end = len(plain)
+		}
+		n, err := w.Write(plain[i:end])
+		assert.Nil(t, err)
+		assert.Equal(t, end-i, n)
+	}
+	assert.Nil(t, w.Close())
+	_, err = w.Write([]byte("after close"))
+	assert.NotNil(t, err)
+
+	r, err := NewDecryptReader(&encr, key)
+	assert.Nil(t, err)
+	decr, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, decr)
+}
+
+func TestDecryptStreamTampered(t *testing.T) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	plain := make([]byte, 2*StreamSegmentSize+100)
+
+	var buf bytes.Buffer
+	assert.Nil(t, EncryptStream(&buf, bytes.NewReader(plain), key))
+	encr := buf.Bytes()
+	headerLen := 2 + 4 + streamSaltSize + streamNoncePrefixSize
+	segmentLen := StreamSegmentSize + 16
+
+	// truncated at a segment boundary
+	for _, n := range []int{headerLen, headerLen + segmentLen, headerLen + 2*segmentLen} {
+		err := DecryptStream(ioutil.Discard, bytes.NewReader(encr[:n]), key)
+		assert.True(t, errors.Is(err, ErrStreamTruncated), n)
+	}
+	// truncated within a segment or the header
+	for _, n := range []int{10, headerLen + 100, len(encr) - 1} {
+		err := DecryptStream(ioutil.Discard, bytes.NewReader(encr[:n]), key)
+		assert.NotNil(t, err, n)
+	}
+
+	// modified header and segment
+	for _, i := range []int{5, headerLen - 1, headerLen + segmentLen + 3} {
+		modified := append([]byte(nil), encr...)
+		modified[i] ^= 1
+		err := DecryptStream(ioutil.Discard, bytes.NewReader(modified), key)
+		assert.NotNil(t, err, i)
+	}
+
+	// reordered segments
+	reordered := append([]byte(nil), encr[:headerLen]...)
+	reordered = append(reordered, encr[headerLen+segmentLen:headerLen+2*segmentLen]...)
+	reordered = append(reordered, encr[headerLen:headerLen+segmentLen]...)
+	reordered = append(reordered, encr[headerLen+2*segmentLen:]...)
+	err := DecryptStream(ioutil.Discard, bytes.NewReader(reordered), key)
+	assert.NotNil(t, err)
+
+	// appended data
+	err = DecryptStream(ioutil.Discard, bytes.NewReader(append(encr, 0)), key)
+	assert.NotNil(t, err)
+}
+
+func TestKeyringEncryptStream(t *testing.T) {
+	key1 := EncryptionKey{ID: "k1", Key: []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")}
+	key2 := EncryptionKey{ID: "k2", Key: []byte("8JtsDUxzjp372XxNKypHB7zQbnjUBBoG")}
+	plain := bytes.Repeat([]byte("My dark, little Secret 🔐 "), 5000)
+
+	k, err := NewKeyring(key1)
+	assert.Nil(t, err)
+	var encr bytes.Buffer
+	assert.Nil(t, k.EncryptStream(&encr, bytes.NewReader(plain)))
+
+	rotated, err := NewKeyring(key2, key1)
+	assert.Nil(t, err)
+	var decr bytes.Buffer
+	assert.Nil(t, rotated.DecryptStream(&decr, bytes.NewReader(encr.Bytes())))
+	assert.Equal(t, plain, decr.Bytes())
+
+	// the longest key ID fits into the stream header
+	longID, err := NewKeyring(EncryptionKey{ID: strings.Repeat("k", 255), Key: key1.Key})
+	assert.Nil(t, err)
+	var encrLong, decrLong bytes.Buffer
+	assert.Nil(t, longID.EncryptStream(&encrLong, bytes.NewReader(plain)))
+	assert.Nil(t, longID.DecryptStream(&decrLong, &encrLong))
+	assert.Equal(t, plain, decrLong.Bytes())
+	_, err = newEncryptWriter(ioutil.Discard, strings.Repeat("k", 256), key1.Key)
+	assert.NotNil(t, err)
+
+	var encr2 bytes.Buffer
+	assert.Nil(t, rotated.EncryptStream(&encr2, bytes.NewReader(plain)))
+	err = k.DecryptStream(ioutil.Discard, &encr2)
+	assert.True(t, errors.Is(err, ErrUnknownKeyID))
+}
+
+func TestEncryptFile(t *testing.T) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	dir, err := ioutil.TempDir("", "strutil")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	plainPath := filepath.Join(dir, "plain.txt")
+	encrPath := filepath.Join(dir, "plain.txt.enc")
+	decrPath := filepath.Join(dir, "decrypted.txt")
+	plain := bytes.Repeat([]byte("My dark, little Secret 🔐 "), 5000)
+	assert.Nil(t, ioutil.WriteFile(plainPath, plain, 0600))
+
+	assert.Nil(t, EncryptFile(encrPath, plainPath, key))
+	// existing files are not overwritten
+	assert.NotNil(t, EncryptFile(encrPath, plainPath, key))
+
+	assert.Nil(t, DecryptFile(decrPath, encrPath, key))
+	decr, err := ioutil.ReadFile(decrPath)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, decr)
+
+	// the output of a failed decryption is removed
+	failedPath := filepath.Join(dir, "failed.txt")
+	assert.NotNil(t, DecryptFile(failedPath, encrPath, "8JtsDUxzjp372XxNKypHB7zQbnjUBBoG"))
+	_, err = os.Stat(failedPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+//////////////////////
+// Benchmarks
+//////////////////////
+
+func BenchmarkEncryptStream(b *testing.B) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	plain := make([]byte, 1024*1024)
+	b.SetBytes(int64(len(plain)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := EncryptStream(ioutil.Discard, bytes.NewReader(plain), key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecryptStream(b *testing.B) {
+	key := "WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9"
+	var encr bytes.Buffer
+	if err := EncryptStream(&encr, io.LimitReader(rand.Reader, 1024*1024), key); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(1024 * 1024)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := DecryptStream(ioutil.Discard, bytes.NewReader(encr.Bytes()), key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned by TokenSigner.Verify.
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// tokenMinSecretLen is the minimum length of token secrets in bytes.
+const tokenMinSecretLen = 32
+
+// TokenKey is a key for signing tokens.
+type TokenKey struct {
+	// ID identifies the key in tokens, it must only contain the characters
+	// A-Z a-z 0-9 - _ and is not secret.
+	ID string
+	// Secret is the HMAC-SHA-256 key of at least 32 bytes, e.g. generated
+	// with RandomString(64, AlphabetAlphaNumeric).
+	Secret []byte
+}
+
+// TokenSigner creates and verifies signed, expiring, purpose-bound tokens,
+// e.g. for email verification and password reset links, which do not need
+// to be stored. Tokens are URL-safe and look like
+//
+//	<key ID>.<base64url(expiry, payload)>.<base64url(HMAC-SHA-256)>
+//
+// The payload is signed but NOT ENCRYPTED, it can be read by anyone having
+// the token. A token is only valid for the purpose it was created for, so a
+// token for verifying an email can not be used for resetting a password.
+//
+// Tokens are signed with the first key and verified with the key of their
+// key ID, so keys can be rotated by adding a new first key and removing the
+// old one after the longest token lifetime. It is safe for concurrent use.
+type TokenSigner struct {
+	keys []TokenKey
+	now  func() time.Time
+}
+
+// NewTokenSigner returns a new TokenSigner signing tokens with the first of
+// the keys and verifying them with all of them.
+func NewTokenSigner(keys ...TokenKey) (*TokenSigner, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("token signer needs at least one key")
+	}
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if !validKeyID(k.ID) {
+			return nil, fmt.Errorf("token key ID '%s' must only contain A-Z a-z 0-9 - _", k.ID)
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("token key ID '%s' is not unique", k.ID)
+		}
+		ids[k.ID] = true
+		if len(k.Secret) < tokenMinSecretLen {
+			return nil, fmt.Errorf("token key '%s' is shorter than %d bytes", k.ID, tokenMinSecretLen)
+		}
+	}
+	return &TokenSigner{keys: keys, now: time.Now}, nil
+}
+
+// Sign returns a token for the purpose having the payload which expires
+// after ttl.
+func (s *TokenSigner) Sign(purpose string, payload []byte, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errors.New("token ttl must be positive")
+	}
+	key := s.keys[0]
+
+	body := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint64(body, uint64(s.now().Add(ttl).Unix()))
+	copy(body[8:], payload)
+
+	signed := key.ID + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := tokenMAC(key.Secret, purpose, signed)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// Verify verifies the signature, purpose and expiry of the token and returns
+// its payload. It returns ErrTokenExpired for expired tokens and
+// ErrTokenInvalid (possibly wrapped) for all other invalid tokens.
+func (s *TokenSigner) Verify(token, purpose string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrTokenInvalid, len(parts))
+	}
+
+	var key *TokenKey
+	for i := range s.keys {
+		if s.keys[i].ID == parts[0] {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown key ID '%s'", ErrTokenInvalid, parts[0])
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrTokenInvalid)
+	}
+	// constant time comparison
+	if !hmac.Equal(mac, tokenMAC(key.Secret, purpose, parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrTokenInvalid)
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(body) < 8 {
+		return nil, fmt.Errorf("%w: malformed payload", ErrTokenInvalid)
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(body)), 0)
+	if !s.now().Before(expires) {
+		return nil, fmt.Errorf("%w at %s", ErrTokenExpired, expires)
+	}
+	return body[8:], nil
+}
+
+// tokenMAC returns the HMAC-SHA-256 of the signed part of a token bound to
+// the purpose.
+func tokenMAC(secret []byte, purpose, signed string) []byte {
+	h := hmac.New(sha256.New, secret)
+	// the length prefix separates purpose and signed part unambiguously
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(purpose)))
+	h.Write([]byte("strutil-token-v1"))
+	h.Write(n[:])
+	h.Write([]byte(purpose))
+	h.Write([]byte(signed))
+	return h.Sum(nil)
+}
+
+// validKeyID reports whether the key ID is not empty and only contains the
+// characters A-Z a-z 0-9 - _, so it can be used in tokens and ciphertexts.
+func validKeyID(id string) bool {
+	return id != "" && strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' ||
+			r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) < 0
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright 2020 iconmobile GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package strutil
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	key1 := TokenKey{ID: "k1", Secret: []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")}
+	key2 := TokenKey{ID: "k2", Secret: []byte("8JtsDUxzjp372XxNKypHB7zQbnjUBBoG")}
+
+	s, err := NewTokenSigner(key1)
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+
+	token, err := s.Sign("verify-email", []byte("user@example.com"), time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, "k1."))
+	assert.Equal(t, token, url.QueryEscape(token))
+
+	payload, err := s.Verify(token, "verify-email")
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.com", string(payload))
+
+	// other purpose
+	_, err = s.Verify(token, "reset-password")
+	assert.True(t, errors.Is(err, ErrTokenInvalid))
+
+	// tampered
+	parts := strings.Split(token, ".")
+	for _, tampered := range []string{
+		"",
+		parts[0] + "." + parts[1],
+		"k3." + parts[1] + "." + parts[2],
+		parts[0] + "." + parts[1] + "x." + parts[2],
+		parts[0] + "." + parts[1] + "." + parts[2][1:],
+		parts[0] + "." + parts[1] + ".!",
+		token + ".",
+	} {
+		_, err = s.Verify(tampered, "verify-email")
+		assert.True(t, errors.Is(err, ErrTokenInvalid), tampered)
+	}
+
+	// expired
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = s.Verify(token, "verify-email")
+	assert.True(t, errors.Is(err, ErrTokenExpired))
+	assert.False(t, errors.Is(err, ErrTokenInvalid))
+
+	// rotation: tokens of the old key are still valid
+	s.now = func() time.Time { return now }
+	rotated, err := NewTokenSigner(key2, key1)
+	assert.Nil(t, err)
+	rotated.now = s.now
+	payload, err = rotated.Verify(token, "verify-email")
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.com", string(payload))
+
+	newToken, err := rotated.Sign("verify-email", nil, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(newToken, "k2."))
+	payload, err = rotated.Verify(newToken, "verify-email")
+	assert.Nil(t, err)
+	assert.Empty(t, payload)
+	_, err = s.Verify(newToken, "verify-email")
+	assert.True(t, errors.Is(err, ErrTokenInvalid))
+
+	// same key ID with other secret
+	other, err := NewTokenSigner(TokenKey{ID: "k1", Secret: key2.Secret})
+	assert.Nil(t, err)
+	_, err = other.Verify(token, "verify-email")
+	assert.True(t, errors.Is(err, ErrTokenInvalid))
+
+	_, err = s.Sign("verify-email", nil, 0)
+	assert.NotNil(t, err)
+}
+
+func TestNewTokenSignerInvalidKeys(t *testing.T) {
+	secret := []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")
+	for name, keys := range map[string][]TokenKey{
+		"none":         nil,
+		"empty ID":     {{Secret: secret}},
+		"invalid ID":   {{ID: "k.1", Secret: secret}},
+		"duplicate ID": {{ID: "k1", Secret: secret}, {ID: "k1", Secret: secret}},
+		"short secret": {{ID: "k1", Secret: secret[:31]}},
+	} {
+		s, err := NewTokenSigner(keys...)
+		assert.NotNil(t, err, name)
+		assert.Nil(t, s, name)
+	}
+}
+
+//////////////////////
+// Benchmarks
+//////////////////////
+
+func BenchmarkTokenSignVerify(b *testing.B) {
+	b.ReportAllocs()
+	s, _ := NewTokenSigner(TokenKey{ID: "k1", Secret: []byte("WbuJjNmPPzqi2ikTPeGFXbTqbad9MuP9")})
+
+	for i := 0; i < b.N; i++ {
+		token, err1 := s.Sign("verify-email", []byte("user@example.com"), time.Hour)
+		_, err2 := s.Verify(token, "verify-email")
+		if err1 != nil || err2 != nil {
+			b.Fatal(err1, err2)
+		}
+	}
+}
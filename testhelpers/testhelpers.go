@@ -46,8 +46,8 @@ func RequireLastLogEntry(
 
 	if len(expectedFieldsContaining) > 0 {
 		actualFields := make(map[string]string)
-		for _, actualField := range lastLog.Context {
-			actualFields[actualField.Key] = fmt.Sprintf("%s", actualField.Interface)
+		for key, value := range lastLog.ContextMap() {
+			actualFields[key] = fmt.Sprintf("%v", value)
 		}
 
 		foundFields := make(map[string]string)